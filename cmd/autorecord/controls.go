@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+// playerControls are the buttons for the player, set to what it's doing now
+func playerControls(client *spotify.Client, playing web.NowPlaying) []web.Control {
+	if !playing.Active {
+		return nil
+	}
+
+	q := "?" + profileQuery(client)
+	toggle := web.Control{Label: "Pause", Action: "/player/pause" + q}
+	if !playing.IsPlaying {
+		toggle = web.Control{Label: "Play", Action: "/player/play" + q, Active: true}
+	}
+	return []web.Control{
+		{Label: "Previous", Action: "/player/previous" + q},
+		toggle,
+		{Label: "Next", Action: "/player/next" + q},
+		{Label: "Go to second", Action: "/player/seek" + q, Input: "position", Value: strconv.Itoa(playing.ProgressMS / 1000)},
+		{Label: "Set volume", Action: "/player/volume" + q, Input: "volume", Value: strconv.Itoa(playing.Volume)},
+	}
+}
+
+// controlHandler runs the player control posted to /player/NAME, then shows
+// what's playing
+func controlHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "player controls have to be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	switch path.Base(req.URL.Path) {
+	case "pause":
+		// remember where we stopped, the record might be put away now
+		state, ok, stateErr := client.Playback()
+		if stateErr == nil && ok {
+			rememberPlayback(client, state)
+		}
+		err = client.Pause()
+	case "play":
+		err = client.Resume()
+	case "next":
+		err = client.Next()
+	case "previous":
+		err = client.Previous()
+	case "seek":
+		var seconds int
+		seconds, err = strconv.Atoi(req.FormValue("position"))
+		if err == nil {
+			err = client.Seek(seconds * 1000)
+		}
+	case "volume":
+		var volume int
+		volume, err = strconv.Atoi(req.FormValue("volume"))
+		if err == nil && (volume < 0 || volume > 100) {
+			err = fmt.Errorf("the volume has to be between 0 and 100")
+		}
+		if err == nil {
+			err = client.SetVolume(volume)
+		}
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	http.Redirect(w, req, "/now-playing?"+profileQuery(client), http.StatusSeeOther)
+}
@@ -4,41 +4,86 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
-	"github.com/jccroft1/autorecord/internal/camera"
+	"github.com/jccroft1/autorecord/internal/config"
 	"github.com/jccroft1/autorecord/internal/spotify"
 	"github.com/jccroft1/autorecord/internal/vision"
 	"github.com/jccroft1/autorecord/internal/web"
+	"rsc.io/qr"
 )
 
 const (
-	skipCamera        = false
-	skipImageSearch   = false
-	skipSpotifySearch = false
-
 	errorText = "Oops, something went wrong..."
 
-	spotifyAuthText   = "Setup your Spotify account. We'll redirect you to login to Spotify so you can approve this app."
+	spotifyAuthText   = "Setup your Spotify account. You'll login to Spotify, on this device or your phone, so you can approve this app."
 	spotifyPlayerText = "We need to choose a default player for the music playback. You'll need to be signed into your Spotify account on that device."
+	visionSetupText   = "Setup Google Vision. We need an API key or service account to recognise your records."
+	spotifyAppText    = "Create a Spotify app, then export its SPOTIFY_CLIENTID to the environment and restart Auto Record."
+
+	spotifyDashboardURL = "https://developer.spotify.com/dashboard"
+
+	// pixels per QR code module, login links make codes about 75 modules wide
+	qrCodeScale = 4
 )
 
+var spotifyProfiles = spotify.NewProfiles(config.Default())
+
+// spotifyClient returns the client for the request's profile parameter, or the
+// current profile when there isn't one
+func spotifyClient(req *http.Request) (*spotify.Client, error) {
+	return spotifyProfiles.Client(req.FormValue("profile"))
+}
+
+// profileQuery keeps the client's profile on links and forms
+func profileQuery(client *spotify.Client) string {
+	return "profile=" + url.QueryEscape(client.Profile)
+}
+
 func main() {
 	http.HandleFunc("/", defaultHandler)
 	http.HandleFunc("/spotify/auth", spotifyAuth)
 	http.HandleFunc("/spotify/callback", spotifyCallback)
+	http.HandleFunc("/spotify/login", spotifyLogin)
+	http.HandleFunc("/spotify/login/qr", spotifyLoginQR)
 	http.HandleFunc("/spotify/player/options", spotifyPlayerOptions)
 	http.HandleFunc("/spotify/player/select", spotifyPlayerSelect)
+	http.HandleFunc("/settings/vision", visionSettings)
+	http.HandleFunc("/settings/spotify", spotifySettings)
+	http.HandleFunc("/settings/profiles", profileSettings)
+	http.HandleFunc("/settings/profiles/switch", profileSwitch)
 	http.HandleFunc("/do", doHandler)
+	http.HandleFunc("/do/choose", chooseHandler)
+	http.HandleFunc("/do/retry", retryHandler)
+	http.HandleFunc("/do/side", sideHandler)
+	http.HandleFunc("/do/resume", resumeHandler)
+	http.HandleFunc("/settings/sides", sidesSettings)
+	http.HandleFunc("/replay", replayHandler)
+	http.HandleFunc("/now-playing", nowPlayingHandler)
+	http.HandleFunc("/now-playing.json", nowPlayingJSON)
+	http.HandleFunc("/player/", controlHandler)
+	http.HandleFunc("/mode", modeHandler)
 
 	log.Println("starting server")
 	http.ListenAndServe(":80", nil)
 }
 
 func spotifyAuth(w http.ResponseWriter, req *http.Request) {
-	url, err := spotify.GetAuthURL()
+	client, err := spotifyClient(req)
 	if err != nil {
-		log.Panicln(err)
-		fmt.Fprint(w, errorText)
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	url, err := client.GetAuthURL()
+	if err != nil {
+		// most likely the app credentials are missing, the home page says how
+		// to set them up
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
@@ -46,7 +91,15 @@ func spotifyAuth(w http.ResponseWriter, req *http.Request) {
 }
 
 func spotifyCallback(w http.ResponseWriter, req *http.Request) {
-	err := spotify.ProcessCallback(req.URL.Query())
+	// the callback is shared, the state tells us which profile is logging in
+	client, ok := spotifyProfiles.ForState(req.URL.Query().Get("state"))
+	if !ok {
+		log.Println("failed to process Spotify callback: the login is unknown or expired")
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err := client.ProcessCallback(req.URL.Query())
 	if err != nil {
 		log.Print("failed to process Spotify callback:", err)
 	}
@@ -54,105 +107,456 @@ func spotifyCallback(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
 }
 
+// spotifyLogin lets the user log in on another device, such as their phone,
+// then paste the address they end up on back here
+func spotifyLogin(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	alerts := []web.Alert{}
+
+	if req.Method == http.MethodPost {
+		err := client.ProcessPasted(req.FormValue("pasted"))
+		if err == nil {
+			http.Redirect(w, req, "/", http.StatusSeeOther)
+			return
+		}
+		log.Println("failed to process pasted Spotify login:", err)
+		alerts = append(alerts, web.Alert{Level: "danger", Text: fmt.Sprint("That didn't work, try logging in again: ", err)})
+	}
+
+	authURL, err := client.GetAuthURL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	alerts = append(alerts, web.Alert{
+		Level: "info",
+		Text:  fmt.Sprintf("After approving, your browser will try to open %v and probably show an error page. That's expected, copy the whole address from the address bar and paste it below.", client.RedirectURL()),
+	})
+
+	web.Show(w, web.Page{
+		Title:  fmt.Sprintf("Log in to Spotify for %v", client.Profile),
+		Alerts: alerts,
+		Questions: []web.Item{
+			{Text: "Scan the code with your phone, or open the link on any device, and approve Auto Record.", Path: authURL, Image: qrCodeURL(client, authURL)},
+		},
+		Form: &web.Form{
+			Action: "/spotify/login?" + profileQuery(client),
+			Fields: []web.Field{
+				{Label: "Address (or just the code) you were sent to", Name: "pasted"},
+			},
+			Submit: "Finish logging in",
+		},
+	})
+}
+
+// qrCodeURL returns an image of a QR code for the login link
+func qrCodeURL(client *spotify.Client, authURL string) string {
+	return "/spotify/login/qr?" + profileQuery(client) + "&url=" + url.QueryEscape(authURL)
+}
+
+// spotifyLoginQR serves a QR code of a login link as a PNG. It's made here so
+// the link, with its state and PKCE challenge, isn't sent anywhere else.
+func spotifyLoginQR(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authURL := req.FormValue("url")
+	if !strings.HasPrefix(authURL, client.AccountsURL+"/authorize?") {
+		http.Error(w, "that isn't a Spotify login link", http.StatusBadRequest)
+		return
+	}
+
+	code, err := qr.Encode(authURL, qr.M)
+	if err != nil {
+		log.Println("unable to make login QR code:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code.Scale = qrCodeScale
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+func spotifySettings(w http.ResponseWriter, req *http.Request) {
+	alerts := []web.Alert{}
+
+	if req.Method == http.MethodPost {
+		err := spotifyProfiles.CurrentClient().SetRedirectURL(req.FormValue("redirect_uri"))
+		if err == nil {
+			err = setResumeMode(req.FormValue("resume_mode"))
+		}
+		if err == nil {
+			err = setSaveSettings(req.FormValue("save_library") == "yes", req.FormValue("session_playlist"))
+		}
+		if err == nil {
+			volume := -1
+			if v := strings.TrimSpace(req.FormValue("volume")); v != "" {
+				volume, err = strconv.Atoi(v)
+			}
+			if err == nil {
+				err = spotifyProfiles.CurrentClient().SetPolicy(spotify.Policy{
+					Shuffle: req.FormValue("shuffle"),
+					Repeat:  req.FormValue("repeat"),
+					Volume:  volume,
+				})
+			}
+		}
+		if err != nil {
+			alerts = append(alerts, web.Alert{Level: "danger", Text: err.Error()})
+		} else {
+			alerts = append(alerts, web.Alert{Level: "success", Text: "Settings saved."})
+		}
+	}
+
+	alerts = append(alerts, web.Alert{
+		Level: "info",
+		Text:  fmt.Sprintf("The redirect URI must also be added to your app on the Spotify dashboard. Leave it blank to use %v.", spotify.DefaultRedirectURL),
+	})
+
+	library := "no"
+	if saveLibrary() {
+		library = "yes"
+	}
+
+	policy := spotifyProfiles.CurrentClient().Policy()
+	volume := ""
+	if policy.Volume >= 0 {
+		volume = strconv.Itoa(policy.Volume)
+	}
+
+	web.Show(w, web.Page{
+		Title:  "Spotify settings",
+		Alerts: alerts,
+		Form: &web.Form{
+			Action: "/settings/spotify",
+			Fields: []web.Field{
+				{Label: "Redirect URI", Name: "redirect_uri", Value: spotifyProfiles.CurrentClient().RedirectURL()},
+				{Label: "When an album we stopped part way is scanned again", Name: "resume_mode", Value: resumeMode(), Options: []string{resumeModeRestart, resumeModeResume}},
+				{Label: "Save scanned albums to your library", Name: "save_library", Value: library, Options: []string{"no", "yes"}},
+				{Label: "Add scanned albums to a playlist, a new one each day or one that keeps growing", Name: "session_playlist", Value: sessionPlaylist(), Options: []string{playlistOff, playlistDaily, playlistRolling}},
+				{Label: "Shuffle when an album starts", Name: "shuffle", Value: policy.Shuffle, Options: []string{spotify.ShuffleOff, spotify.ShuffleOn, spotify.PolicyLeave}},
+				{Label: "Repeat when an album starts", Name: "repeat", Value: policy.Repeat, Options: []string{spotify.RepeatOff, spotify.RepeatContext, spotify.RepeatTrack, spotify.PolicyLeave}},
+				{Label: "Volume for this player when an album starts, 0 to 100 (leave blank to not change it)", Name: "volume", Value: volume, Type: "number"},
+			},
+		},
+	})
+}
+
 func spotifyPlayerOptions(w http.ResponseWriter, req *http.Request) {
-	devices, err := spotify.GetPlayers()
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	devices, err := client.GetPlayers()
 	if err != nil {
 		log.Panicln(err)
 		fmt.Fprint(w, errorText)
 		return
 	}
 
+	alerts := []web.Alert{}
+	if req.Method == http.MethodPost {
+		fallbacks := []string{}
+		for _, name := range strings.Split(req.FormValue("fallbacks"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				fallbacks = append(fallbacks, name)
+			}
+		}
+		client.SetFallbacks(fallbacks)
+		alerts = append(alerts, web.Alert{Level: "success", Text: "Fallback players saved."})
+	}
+	if client.HasPlayer() {
+		alerts = append(alerts, web.Alert{Level: "info", Text: fmt.Sprintf("Playing on %v.", client.PlayerName())})
+	}
+
 	items := []web.Item{}
 	for _, device := range devices {
 		if device.Restricted {
 			continue
 		}
 		items = append(items,
-			web.Item{Text: fmt.Sprintf("%v (%v)", device.Name, device.Type), Path: fmt.Sprintf("/spotify/player/select?id=%v", device.ID)},
+			web.Item{Text: fmt.Sprintf("%v (%v)", device.Name, device.Type), Path: fmt.Sprintf("/spotify/player/select?id=%v&%v", device.ID, profileQuery(client))},
 		)
 	}
 
 	web.Show(w, web.Page{
-		Title:      "Choose a player below...",
-		Questions:  items,
+		Title:     "Choose a player below...",
+		Alerts:    alerts,
+		Questions: items,
+		Form: &web.Form{
+			Action: "/spotify/player/options?" + profileQuery(client),
+			Fields: []web.Field{
+				{Label: "Players to use when this one can't be found, in order (names, comma separated)", Name: "fallbacks", Value: strings.Join(client.Fallbacks(), ", ")},
+			},
+		},
 		ShowButton: false,
 	})
 }
 
 func spotifyPlayerSelect(w http.ResponseWriter, req *http.Request) {
-	spotify.ProcessPlayer(req.URL.Query().Get("id"))
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// keep the name and type too, the ID can change
+	id := req.URL.Query().Get("id")
+	device := spotify.Device{ID: id}
+	devices, err := client.GetPlayers()
+	if err != nil {
+		log.Println("unable to find the player's name:", err)
+	}
+	for _, d := range devices {
+		if d.ID == id {
+			device = d
+		}
+	}
+	client.SelectPlayer(device)
 
 	http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
 }
 
-func doHandler(w http.ResponseWriter, req *http.Request) {
-	var image string
-	var err error
-	if skipCamera {
-		image, err = camera.OpenImage("file2.jpg")
-		if err != nil {
-			log.Fatal(err)
+func visionSettings(w http.ResponseWriter, req *http.Request) {
+	alerts := []web.Alert{}
+	settings := vision.CurrentSettings()
+
+	if req.Method == http.MethodPost {
+		settings = vision.Settings{
+			Recognizer:        req.FormValue("recognizer"),
+			BaseURL:           req.FormValue("url"),
+			Auth:              req.FormValue("auth"),
+			APIKey:            req.FormValue("key"),
+			KeyFile:           req.FormValue("key_file"),
+			TokenURL:          req.FormValue("token_url"),
+			RecognizerURL:     req.FormValue("recognizer_url"),
+			RecognizerTimeout: req.FormValue("recognizer_timeout"),
 		}
-	} else {
-		image, err = camera.Snap()
-		if err != nil {
-			log.Fatal(err)
+
+		threshold, thresholdErr := parseThreshold("confidence threshold", req.FormValue("threshold"))
+		match, matchErr := parseThreshold("match threshold", req.FormValue("match_threshold"))
+		budget, budgetErr := strconv.Atoi(strings.TrimSpace(req.FormValue("budget")))
+		warning, warningErr := strconv.Atoi(strings.TrimSpace(req.FormValue("warning")))
+		var err error
+		switch {
+		case thresholdErr != nil:
+			err = thresholdErr
+		case matchErr != nil:
+			err = matchErr
+		case budgetErr != nil || budget < 0:
+			err = fmt.Errorf("the monthly budget has to be a whole number, 0 for no limit")
+		case warningErr != nil || warning < 0:
+			err = fmt.Errorf("the warning has to be a whole number, 0 for 80%% of the budget")
+		default:
+			err = vision.SaveSettings(settings)
+		}
+		if err == nil {
+			setConfidenceThreshold(threshold)
+			setMatchThreshold(match)
+			vision.SetBudget(budget, warning)
 		}
-	}
 
-	var text string
-	if skipImageSearch {
-		text = "parachutes coldplay"
-	} else {
-		text, err = vision.Search(image)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("failed to save vision settings:", err)
+			alerts = append(alerts, web.Alert{Level: "danger", Text: fmt.Sprint("Those settings didn't work: ", err)})
+		} else {
+			alerts = append(alerts, web.Alert{Level: "success", Text: "Settings saved and checked with the recognizer."})
 		}
-		log.Println("image search result", text)
+	}
+
+	usage := vision.Usage()
+	alerts = append(alerts, usageAlert(usage))
+	hits, misses := vision.CacheStats()
+	alerts = append(alerts, web.Alert{
+		Level: "info",
+		Text:  fmt.Sprintf("Vision cache since startup: %v hits, %v misses", hits, misses),
+	})
+
+	if vision.EnvOverride() {
+		alerts = append(alerts, web.Alert{Level: "warning", Text: "Some settings are set by the environment and will override anything saved here."})
 	}
 
 	web.Show(w, web.Page{
-		Title:      fmt.Sprintln("Found: ", text),
-		Questions:  []web.Item{},
-		ShowButton: true,
+		Title:  "Recognition settings",
+		Alerts: alerts,
+		Form: &web.Form{
+			Action: "/settings/vision",
+			Fields: []web.Field{
+				{Label: "Recognizer", Name: "recognizer", Value: settings.Recognizer, Options: []string{vision.RecognizerGoogle, vision.RecognizerHTTP}},
+				{Label: "Google Vision API URL", Name: "url", Value: settings.BaseURL},
+				{Label: "Authentication", Name: "auth", Value: settings.Auth, Options: []string{vision.AuthAPIKey, vision.AuthServiceAccount}},
+				{Label: "API key (leave blank to keep the saved key)", Name: "key", Type: "password"},
+				{Label: "Service account key file", Name: "key_file", Value: settings.KeyFile},
+				{Label: "Token URL (leave blank to use the key file's)", Name: "token_url", Value: settings.TokenURL},
+				{Label: "Self-hosted recognizer URL (also used when the Google budget is used up)", Name: "recognizer_url", Value: settings.RecognizerURL},
+				{Label: "Self-hosted recognizer timeout", Name: "recognizer_timeout", Value: settings.RecognizerTimeout},
+				{Label: "Confidence threshold, below this we ask which record it is (0 to 1)", Name: "threshold", Value: fmt.Sprint(confidenceThreshold())},
+				{Label: "Spotify match threshold, below this we ask which album it is (0 to 1)", Name: "match_threshold", Value: fmt.Sprint(matchThreshold())},
+				{Label: "Monthly call budget (0 for no limit)", Name: "budget", Value: strconv.Itoa(usage.Budget), Type: "number"},
+				{Label: "Warn after this many calls a month (0 for 80% of the budget)", Name: "warning", Value: strconv.Itoa(usage.Warning), Type: "number"},
+			},
+			Submit: "Check and save",
+		},
 	})
+}
 
-	var result string
-	if skipSpotifySearch {
-		result = "spotify:album:6ZG5lRT77aJ3btmArcykra"
-	} else {
-		result, err = spotify.SearchAlbum(text)
+func usageAlert(usage vision.UsageReport) web.Alert {
+	text := fmt.Sprintf("Google Vision calls: %v today, %v this month", usage.Today, usage.Month)
+	if usage.Budget > 0 {
+		text += fmt.Sprintf(" of a %v budget", usage.Budget)
+	}
+
+	switch {
+	case usage.Exceeded():
+		return web.Alert{Level: "danger", Text: text + ". The budget is used up, scanning is paused until next month."}
+	case usage.Warn():
+		return web.Alert{Level: "warning", Text: text + ". You're close to the budget."}
+	}
+	return web.Alert{Level: "info", Text: text + "."}
+}
+
+// profileSettings adds and removes the household's Spotify profiles
+func profileSettings(w http.ResponseWriter, req *http.Request) {
+	alerts := []web.Alert{}
+
+	if req.Method == http.MethodPost {
+		var err error
+		if name := req.FormValue("add"); name != "" {
+			err = spotifyProfiles.Add(name)
+		}
+		if name := req.FormValue("remove"); err == nil && name != "" {
+			err = spotifyProfiles.Remove(name)
+		}
 		if err != nil {
-			log.Println(err)
-			fmt.Fprint(w, errorText)
-			return
+			alerts = append(alerts, web.Alert{Level: "danger", Text: err.Error()})
+		} else {
+			alerts = append(alerts, web.Alert{Level: "success", Text: "Profiles saved."})
+		}
+	}
+
+	current := spotifyProfiles.Current()
+	items := []web.Item{}
+	removable := []string{""}
+	for _, name := range spotifyProfiles.Names() {
+		client, err := spotifyProfiles.Client(name)
+		if err != nil {
+			continue
+		}
+
+		status := "not logged in"
+		if client.IsAuthed() {
+			status = "logged in"
+		}
+		if name == current {
+			status += ", current"
+		}
+		items = append(items, web.Item{
+			Text: fmt.Sprintf("%v (%v)", name, status),
+			Path: "/settings/profiles/switch?profile=" + url.QueryEscape(name),
+		})
+
+		if name != spotify.DefaultProfile {
+			removable = append(removable, name)
 		}
-		log.Println("Spotify result: ", result)
 	}
 
-	err = spotify.PlayItem(result)
+	web.Show(w, web.Page{
+		Title:     "Spotify profiles",
+		Alerts:    alerts,
+		Questions: items,
+		Form: &web.Form{
+			Action: "/settings/profiles",
+			Fields: []web.Field{
+				{Label: "Add a profile", Name: "add"},
+				{Label: "Remove a profile (logs it out)", Name: "remove", Options: removable},
+			},
+		},
+	})
+}
+
+// profileSwitch makes the profile the one scans play on
+func profileSwitch(w http.ResponseWriter, req *http.Request) {
+	err := spotifyProfiles.Switch(req.FormValue("profile"))
 	if err != nil {
-		log.Println(err)
-		fmt.Fprint(w, errorText)
-		return
+		log.Println("failed to switch profile:", err)
 	}
+
+	http.Redirect(w, req, "/", http.StatusSeeOther)
 }
 
 func defaultHandler(w http.ResponseWriter, req *http.Request) {
+	client := spotifyProfiles.CurrentClient()
 	todo := []web.Item{}
 
-	if !spotify.IsAuthed() {
-		todo = append(todo, web.Item{Text: spotifyAuthText, Path: "/spotify/auth"})
+	if !vision.IsConfigured() {
+		todo = append(todo, web.Item{Text: visionSetupText, Path: "/settings/vision"})
+	}
+
+	if !client.HasCredentials() {
+		todo = append(todo, web.Item{Text: spotifyAppText, Path: spotifyDashboardURL})
+	} else if !client.IsAuthed() {
+		todo = append(todo, web.Item{Text: spotifyAuthText, Path: "/spotify/login"})
 	} else {
-		if !spotify.HasPlayer() {
+		if !client.HasPlayer() {
+			// choose the player if there's only one
+			err := client.ResolvePlayer()
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		if !client.HasPlayer() {
 			todo = append(todo, web.Item{Text: spotifyPlayerText, Path: "/spotify/player/options"})
 		}
 	}
 
+	alerts := []web.Alert{}
+	if usage := vision.Usage(); usage.Warn() {
+		alerts = append(alerts, usageAlert(usage))
+	}
+
+	// scans still play without the permissions to save them, so this doesn't
+	// hold up scanning
+	ready := []web.Item{{Text: "See what's playing.", Path: "/now-playing"}}
+	if client.IsAuthed() && needsSaveLogin(client) {
+		alerts = append(alerts, web.Alert{Level: "warning", Text: spotifyScopeText})
+		ready = append(ready, web.Item{Text: "Log in to Spotify again.", Path: "/spotify/login"})
+	}
+
+	// only households with more than one profile need to see them
+	var switcher *web.Form
+	if names := spotifyProfiles.Names(); len(names) > 1 {
+		switcher = &web.Form{
+			Action: "/settings/profiles/switch",
+			Fields: []web.Field{
+				{Label: "Playing on Spotify profile", Name: "profile", Value: client.Profile, Options: names},
+			},
+			Submit: "Switch",
+		}
+	}
+
 	if len(todo) > 0 {
 		web.Show(w, web.Page{
 			Title:      "We need to sort out some stuff...",
+			Alerts:     alerts,
 			Questions:  todo,
+			Form:       switcher,
 			ShowButton: false,
 		})
 		return
@@ -160,7 +564,10 @@ func defaultHandler(w http.ResponseWriter, req *http.Request) {
 
 	web.Show(w, web.Page{
 		Title:      "You're good to go!",
-		Questions:  []web.Item{},
+		Alerts:     alerts,
+		Questions:  ready,
+		Controls:   []web.Control{modeControl()},
+		Form:       switcher,
 		ShowButton: true,
 	})
 }
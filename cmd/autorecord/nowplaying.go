@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+// nowPlaying returns what the client's profile is playing
+func nowPlaying(client *spotify.Client) (web.NowPlaying, error) {
+	state, ok, err := client.Playback()
+	if err != nil {
+		return web.NowPlaying{Refresh: "/now-playing.json?" + profileQuery(client)}, err
+	}
+	return playingCard(client, state, ok), nil
+}
+
+// playingCard describes the playback for the now playing card and its
+// controls
+func playingCard(client *spotify.Client, state spotify.PlaybackState, ok bool) web.NowPlaying {
+	playing := web.NowPlaying{Refresh: "/now-playing.json?" + profileQuery(client)}
+	if !ok || state.Item == nil {
+		return playing
+	}
+
+	artists := []string{}
+	for _, artist := range state.Item.Artists {
+		artists = append(artists, artist.Name)
+	}
+	playing = web.NowPlaying{
+		Refresh:    playing.Refresh,
+		Active:     true,
+		IsPlaying:  state.IsPlaying,
+		Track:      state.Item.Name,
+		Artists:    strings.Join(artists, ", "),
+		Album:      state.Item.Album.Name,
+		AlbumURI:   state.Item.Album.URI,
+		ImageURL:   state.Item.Album.ImageURL(),
+		Device:     state.Device.Name,
+		Volume:     state.Device.Volume,
+		ProgressMS: state.ProgressMS,
+		DurationMS: state.Item.DurationMS,
+	}
+	playing.Controls = playerControls(client, playing)
+	return playing
+}
+
+// watchPlayback returns what the client's profile is playing, forgetting the
+// position of albums that have been played to the end
+func watchPlayback(client *spotify.Client) (web.NowPlaying, error) {
+	state, ok, err := client.Playback()
+	if err != nil {
+		return web.NowPlaying{}, err
+	}
+	if ok {
+		forgetFinished(client, state)
+	}
+	return playingCard(client, state, ok), nil
+}
+
+func nowPlayingHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	playing, err := watchPlayback(client)
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	alerts := []web.Alert{}
+	if playMode() == playModeQueue {
+		alerts = append(alerts, queuedAlert())
+	}
+
+	web.Show(w, web.Page{
+		Title:      "Now playing",
+		Alerts:     alerts,
+		NowPlaying: &playing,
+		Controls:   []web.Control{modeControl()},
+		ShowButton: true,
+	})
+}
+
+// nowPlayingJSON serves the now playing page's data, it refreshes itself from
+// here
+func nowPlayingJSON(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	client, err := spotifyClient(req)
+	var playing web.NowPlaying
+	if err == nil {
+		playing, err = watchPlayback(client)
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(playing)
+}
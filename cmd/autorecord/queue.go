@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jccroft1/autorecord/internal/bundle"
+	"github.com/jccroft1/autorecord/internal/config"
+	"github.com/jccroft1/autorecord/internal/history"
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+const (
+	// Config key
+	configPlayMode = "scan_play_mode"
+
+	// Play modes, whether a scanned album replaces what's playing or is added
+	// to the queue after it
+	playModeReplace = "replace"
+	playModeQueue   = "queue"
+)
+
+// queued holds the albums added to the queue since autorecord started
+var queued = struct {
+	sync.Mutex
+	albums []spotify.Album
+}{}
+
+func playMode() string {
+	if config.Get(configPlayMode) == playModeQueue {
+		return playModeQueue
+	}
+	return playModeReplace
+}
+
+// queue adds the album's tracks to the queue after whatever is playing
+func queue(w http.ResponseWriter, client *spotify.Client, album spotify.Album, b *bundle.Bundle) {
+	done, total, err := client.QueueAlbum(album.URI)
+	if done == 0 && err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	// the tracks that made it stay queued, so the album counts as queued
+	var alerts []web.Alert
+	title := fmt.Sprintf("Queued: %v", albumText(album))
+	if err != nil {
+		log.Println("queued part of the album:", err)
+		title = fmt.Sprintf("Queued part of %v", albumText(album))
+		alerts = append(alerts, web.Alert{
+			Level: "warning",
+			Text:  fmt.Sprintf("Only %v of its %v tracks were queued: %v", done, total, err),
+		})
+	}
+	if !replaying(b) {
+		history.Add(history.Play{
+			URI:     album.URI,
+			Artists: album.ArtistNames(),
+			Album:   album.Name,
+		})
+
+		queued.Lock()
+		queued.albums = append(queued.albums, album)
+		queued.Unlock()
+
+		alerts = append(alerts, saveScan(client, album)...)
+	}
+
+	web.Show(w, web.Page{
+		Title:      title,
+		Alerts:     append(alerts, queuedAlert()),
+		Questions:  []web.Item{{Text: "See what's playing.", Path: "/now-playing?" + profileQuery(client)}},
+		ShowButton: true,
+	})
+}
+
+// queuedAlert lists the albums queued this session
+func queuedAlert() web.Alert {
+	queued.Lock()
+	defer queued.Unlock()
+
+	if len(queued.albums) == 0 {
+		return web.Alert{Level: "info", Text: "Nothing has been queued yet."}
+	}
+	names := []string{}
+	for _, album := range queued.albums {
+		names = append(names, albumText(album))
+	}
+	return web.Alert{Level: "info", Text: fmt.Sprintf("Queued so far: %v", strings.Join(names, " / "))}
+}
+
+// modeControl switches between replacing and queueing, it's highlighted when
+// queueing
+func modeControl() web.Control {
+	if playMode() == playModeQueue {
+		return web.Control{Label: "Queue mode is on", Action: "/mode?mode=" + playModeReplace, Active: true}
+	}
+	return web.Control{Label: "Queue mode is off", Action: "/mode?mode=" + playModeQueue}
+}
+
+// modeHandler sets the play mode, then goes back to the page it was set from
+func modeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "the mode has to be posted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mode := req.FormValue("mode")
+	if mode != playModeReplace && mode != playModeQueue {
+		log.Println("unknown play mode:", mode)
+	} else {
+		config.Set(configPlayMode, mode)
+	}
+
+	http.Redirect(w, req, localPath(req.Referer()), http.StatusSeeOther)
+}
+
+// localPath returns the path and query of the address if it's one of ours to
+// go back to, the home page otherwise. Other sites, including protocol
+// relative "//host" paths, aren't followed.
+func localPath(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || address == "" {
+		return "/"
+	}
+	back := u.RequestURI()
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") {
+		return "/"
+	}
+	return back
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"": "/",
+		"http://autorecord.local/now-playing?profile=kid": "/now-playing?profile=kid",
+		"https://evil.example/phish":                      "/phish",
+		"http://autorecord.local//evil.example":           "/",
+		`http://autorecord.local/\evil.example`:           "/%5Cevil.example",
+		"/settings/spotify":                               "/settings/spotify",
+		"mailto:someone@example.com":                      "/",
+	}
+	for address, want := range tests {
+		if got := localPath(address); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jccroft1/autorecord/internal/config"
+	"github.com/jccroft1/autorecord/internal/resume"
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+const (
+	// Config key
+	configResumeMode = "scan_resume_mode"
+
+	// Resume modes, what happens when an album we stopped part way through is
+	// scanned again
+	resumeModeRestart = "restart"
+	resumeModeResume  = "resume"
+
+	// albums this close to the end of their last track have been played
+	// through, there's nothing to carry on from
+	finishedMarginMS = 10000
+)
+
+func resumeMode() string {
+	if config.Get(configResumeMode) == resumeModeResume {
+		return resumeModeResume
+	}
+	return resumeModeRestart
+}
+
+func setResumeMode(mode string) error {
+	if mode != resumeModeRestart && mode != resumeModeResume {
+		return fmt.Errorf("%v isn't a resume mode", mode)
+	}
+	config.Set(configResumeMode, mode)
+	return nil
+}
+
+// rememberPlayback stores where the album playing got to, it's called before
+// something else replaces it. Albums played to the end or barely started are
+// forgotten instead.
+func rememberPlayback(client *spotify.Client, state spotify.PlaybackState) {
+	if state.Context == nil || state.Context.Type != "album" || state.Item == nil {
+		return
+	}
+
+	started := state.Item.TrackNumber > 1 || state.Item.DiscNumber > 1 || state.ProgressMS >= finishedMarginMS
+	if !started || albumFinished(client, state) {
+		resume.Forget(client.Profile, state.Context.URI)
+		return
+	}
+
+	resume.Set(client.Profile, state.Context.URI, resume.Position{
+		TrackURI:   state.Item.URI,
+		TrackName:  state.Item.Name,
+		ProgressMS: state.ProgressMS,
+	})
+}
+
+// forgetFinished drops the position of the album playing once it reaches the
+// end of its last track
+func forgetFinished(client *spotify.Client, state spotify.PlaybackState) {
+	if _, ok := resume.Get(client.Profile, state.ContextURI()); !ok {
+		return
+	}
+	if albumFinished(client, state) {
+		log.Println("finished", state.ContextURI(), "forgetting where it was stopped")
+		resume.Forget(client.Profile, state.ContextURI())
+	}
+}
+
+// albumFinished reports whether playback is at the end of the album's last
+// track, or stopped on it
+func albumFinished(client *spotify.Client, state spotify.PlaybackState) bool {
+	if state.Context == nil || state.Context.Type != "album" || state.Item == nil {
+		return false
+	}
+	ending := state.Item.DurationMS-state.ProgressMS <= finishedMarginMS
+	stopped := !state.IsPlaying && state.ProgressMS == 0
+	if !ending && !stopped {
+		return false
+	}
+
+	tracks, err := client.AlbumTracks(state.Context.URI)
+	if err != nil || len(tracks) == 0 {
+		return false
+	}
+	return tracks[len(tracks)-1].URI == state.Item.URI
+}
+
+// resumeRequest plays the album from where we stopped it
+func resumeRequest(albumURI string, position resume.Position) spotify.PlayRequest {
+	return spotify.PlayRequest{
+		URI:        albumURI,
+		Offset:     &spotify.Offset{URI: position.TrackURI},
+		PositionMS: position.ProgressMS,
+	}
+}
+
+// resumeItem offers to carry on from where the album was stopped, or to start
+// it again if it has just been resumed. Restarting forgets the position, so
+// the link carries it.
+func resumeItem(client *spotify.Client, albumURI string, position resume.Position, saved, resumed bool) (web.Item, bool) {
+	if resumed {
+		return web.Item{
+			Text: "Start from the beginning instead",
+			Path: fmt.Sprintf("/do/side?album=%v&side=A&%v", url.QueryEscape(albumURI), profileQuery(client)),
+		}, true
+	}
+
+	if !saved {
+		return web.Item{}, false
+	}
+	v := url.Values{
+		"album":    []string{albumURI},
+		"track":    []string{position.TrackURI},
+		"name":     []string{position.TrackName},
+		"position": []string{strconv.Itoa(position.ProgressMS)},
+	}
+	return web.Item{
+		Text: fmt.Sprintf("Carry on from %v, where you stopped", position.TrackName),
+		Path: fmt.Sprintf("/do/resume?%v&%v", v.Encode(), profileQuery(client)),
+	}, true
+}
+
+// resumeHandler plays the album from where we stopped it
+func resumeHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	albumURI := req.FormValue("album")
+	if !spotify.IsAlbumURI(albumURI) {
+		web.Show(w, web.Page{Title: "That isn't an album.", ShowButton: true})
+		return
+	}
+
+	// links from a restart carry the position, it has been forgotten since
+	position, ok := resume.Get(client.Profile, albumURI)
+	if track := req.FormValue("track"); track != "" {
+		progress, _ := strconv.Atoi(req.FormValue("position"))
+		position = resume.Position{TrackURI: track, TrackName: req.FormValue("name"), ProgressMS: progress}
+		ok = true
+	}
+	if !ok {
+		web.Show(w, web.Page{Title: "We don't know where that album was stopped.", ShowButton: true})
+		return
+	}
+
+	state, playing, err := client.Playback()
+	if err == nil && playing && state.ContextURI() != albumURI {
+		rememberPlayback(client, state)
+	}
+
+	err = client.Play(resumeRequest(albumURI, position))
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	web.Show(w, web.Page{
+		Title:      fmt.Sprintf("Carrying on from %v", position.TrackName),
+		ShowButton: true,
+	})
+}
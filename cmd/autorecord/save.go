@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/config"
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+const (
+	// Config keys
+	configSaveLibrary     = "scan_save_library"
+	configSessionPlaylist = "scan_session_playlist"
+
+	// Session playlist modes, a new playlist each day or one that keeps growing
+	playlistOff     = "off"
+	playlistDaily   = "daily"
+	playlistRolling = "rolling"
+
+	playlistName        = "autorecord sessions"
+	playlistDailyFormat = "autorecord session %v"
+	playlistDescription = "Records played with Auto Record."
+
+	spotifyScopeText = "Log in to Spotify again, Auto Record needs more permissions to save albums to your library and playlists."
+)
+
+func saveLibrary() bool {
+	return config.Get(configSaveLibrary) == "true"
+}
+
+func sessionPlaylist() string {
+	switch mode := config.Get(configSessionPlaylist); mode {
+	case playlistDaily, playlistRolling:
+		return mode
+	}
+	return playlistOff
+}
+
+func setSaveSettings(library bool, playlist string) error {
+	if playlist != playlistOff && playlist != playlistDaily && playlist != playlistRolling {
+		return fmt.Errorf("%v isn't a playlist setting", playlist)
+	}
+	config.Set(configSaveLibrary, fmt.Sprint(library))
+	config.Set(configSessionPlaylist, playlist)
+	return nil
+}
+
+// needsSaveLogin reports whether saving albums is turned on but the account
+// logged in before it had the permissions for it
+func needsSaveLogin(client *spotify.Client) bool {
+	if !saveLibrary() && sessionPlaylist() == playlistOff {
+		return false
+	}
+	missing := client.MissingScopes()
+	if len(missing) > 0 {
+		log.Println("spotify permissions missing:", missing)
+		return true
+	}
+	return false
+}
+
+// saveScan keeps a digital record of the album we played, in the library and
+// the session playlist if they're turned on. Failures don't stop the music,
+// they're returned as alerts.
+func saveScan(client *spotify.Client, album spotify.Album) []web.Alert {
+	playlist := sessionPlaylist()
+	if !saveLibrary() && playlist == playlistOff {
+		return nil
+	}
+	if needsSaveLogin(client) {
+		return []web.Alert{{Level: "warning", Text: spotifyScopeText}}
+	}
+
+	alerts := []web.Alert{}
+	if saveLibrary() {
+		err := client.SaveAlbum(album.URI)
+		if err != nil {
+			log.Println("unable to save album:", err)
+			alerts = append(alerts, web.Alert{Level: "warning", Text: "We couldn't save the album to your library."})
+		}
+	}
+
+	if playlist != playlistOff {
+		err := addToSession(client, album, playlist)
+		if err != nil {
+			log.Println("unable to add album to the session playlist:", err)
+			alerts = append(alerts, web.Alert{Level: "warning", Text: "We couldn't add the album to the session playlist."})
+		}
+	}
+	return alerts
+}
+
+func addToSession(client *spotify.Client, album spotify.Album, mode string) error {
+	name := playlistName
+	if mode == playlistDaily {
+		name = fmt.Sprintf(playlistDailyFormat, time.Now().Format("2006-01-02"))
+	}
+
+	playlist, err := client.Playlist(name, playlistDescription)
+	if err != nil {
+		return err
+	}
+
+	tracks, err := client.AlbumTracks(album.URI)
+	if err != nil {
+		return err
+	}
+	uris := []string{}
+	for _, track := range tracks {
+		uris = append(uris, track.URI)
+	}
+	return client.AddToPlaylist(playlist.ID, uris)
+}
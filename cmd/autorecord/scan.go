@@ -0,0 +1,519 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/bundle"
+	"github.com/jccroft1/autorecord/internal/camera"
+	"github.com/jccroft1/autorecord/internal/config"
+	"github.com/jccroft1/autorecord/internal/history"
+	"github.com/jccroft1/autorecord/internal/resume"
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/vision"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+const (
+	skipCamera        = false
+	skipImageSearch   = false
+	skipSpotifySearch = false
+
+	// Config keys
+	configThreshold      = "scan_confidence_threshold"
+	configMatchThreshold = "scan_match_threshold"
+	configRecord         = "scan_record"
+	configBundleDir      = "scan_bundle_dir"
+
+	defaultBundleDir = "bundles"
+
+	// best guess labels score from 0.5, so by default they play straight
+	// away like they always have
+	defaultThreshold      = 0.5
+	defaultMatchThreshold = 0.5
+	maxChoices            = 4
+
+	// scans nobody carried on with are dropped after this
+	pendingTimeout = 10 * time.Minute
+)
+
+// pending holds the albums we've asked the user to choose between, by scan
+var pending = struct {
+	sync.Mutex
+	scans map[string]pendingScan
+}{scans: make(map[string]pendingScan)}
+
+type pendingScan struct {
+	candidates []vision.Candidate
+	albums     []spotify.Album
+	bundle     *bundle.Bundle
+	client     *spotify.Client
+	added      time.Time
+}
+
+// addPending stores the scan until the user, or a retry, carries on with it.
+// Scans that have been waiting too long are dropped.
+func addPending(p pendingScan) string {
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 36)
+	p.added = now
+
+	pending.Lock()
+	defer pending.Unlock()
+	for old, scan := range pending.scans {
+		if now.Sub(scan.added) > pendingTimeout {
+			delete(pending.scans, old)
+		}
+	}
+	pending.scans[id] = p
+	return id
+}
+
+// takePending removes and returns the scan, it's empty if the scan is unknown
+// or expired
+func takePending(id string) pendingScan {
+	pending.Lock()
+	defer pending.Unlock()
+	p, ok := pending.scans[id]
+	delete(pending.scans, id)
+	if !ok || time.Since(p.added) > pendingTimeout {
+		return pendingScan{}
+	}
+	return p
+}
+
+func confidenceThreshold() float64 {
+	threshold, err := strconv.ParseFloat(config.Get(configThreshold), 64)
+	if err != nil {
+		return defaultThreshold
+	}
+	return threshold
+}
+
+func setConfidenceThreshold(threshold float64) {
+	config.Set(configThreshold, fmt.Sprint(threshold))
+}
+
+// matchThreshold is how much the best Spotify album has to resemble what was
+// recognised to play it without asking
+func matchThreshold() float64 {
+	threshold, err := strconv.ParseFloat(config.Get(configMatchThreshold), 64)
+	if err != nil {
+		return defaultMatchThreshold
+	}
+	return threshold
+}
+
+func setMatchThreshold(threshold float64) {
+	config.Set(configMatchThreshold, fmt.Sprint(threshold))
+}
+
+// parseThreshold reads a threshold from the settings page, they're between 0
+// and 1 like the scores they're compared with
+func parseThreshold(name, value string) (float64, error) {
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("the %v has to be a number from 0 to 1", name)
+	}
+	return threshold, nil
+}
+
+// doHandler scans the record, add ?record=1 to record a bundle of the scan and
+// ?profile=NAME to play on that profile's Spotify rather than the current one
+func doHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	var b *bundle.Bundle
+	if req.URL.Query().Get("record") != "" || config.Get(configRecord) == "true" {
+		b, err = bundle.Record(filepath.Join(bundleDir(), time.Now().Format("20060102-150405")))
+		if err != nil {
+			log.Println("unable to record scan:", err)
+		} else {
+			log.Println("recording scan to", b.Dir)
+			client = scanClient(client, b)
+		}
+	}
+
+	var image string
+	if skipCamera {
+		image, err = camera.OpenImage("file2.jpg")
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		image, err = camera.Snap()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if b != nil {
+		err = b.SaveImage(image)
+		if err != nil {
+			log.Println("unable to save scan image:", err)
+		}
+	}
+
+	scan(w, image, b, client)
+}
+
+// replayHandler runs a scan against a recorded bundle with no network
+func replayHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	name := filepath.Base(req.URL.Query().Get("bundle"))
+	b, err := bundle.Replay(filepath.Join(bundleDir(), name))
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+	log.Println("replaying scan from", b.Dir)
+	client = scanClient(client, b)
+
+	image, err := b.Image()
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText})
+		return
+	}
+
+	scan(w, image, b, client)
+}
+
+// scanClient sends the scan's Spotify requests through its bundle. A replay
+// doesn't change anything stored for the account.
+func scanClient(client *spotify.Client, b *bundle.Bundle) *spotify.Client {
+	if b.Replaying() {
+		return client.Offline(b.Client())
+	}
+	return client.WithHTTPClient(b.Client())
+}
+
+// replaying reports whether the scan is a replay, they play the album but
+// leave local state such as the history alone
+func replaying(b *bundle.Bundle) bool {
+	return b != nil && b.Replaying()
+}
+
+// scan recognises the image and plays it on the client's account, or asks
+// which record it is. With a bundle the client must be its scanClient.
+func scan(w http.ResponseWriter, image string, b *bundle.Bundle, client *spotify.Client) {
+	var candidates []vision.Candidate
+	if skipImageSearch {
+		candidates = []vision.Candidate{vision.ParseLabel("parachutes coldplay", history.Artists())}
+	} else {
+		var err error
+		candidates, err = vision.CurrentSettings().WithBundle(b).Recognize(image, history.Artists())
+		if err == vision.ErrBudgetExceeded {
+			log.Println(err)
+			web.Show(w, web.Page{
+				Title:  errorText,
+				Alerts: []web.Alert{usageAlert(vision.Usage())},
+			})
+			return
+		}
+		if err != nil {
+			log.Println("image search failed:", err)
+			web.Show(w, web.Page{Title: errorText})
+			return
+		}
+		log.Printf("image search result %+v", candidates[0])
+	}
+
+	scanCandidates(w, candidates, b, client)
+}
+
+// scanCandidates finds the recognised album on Spotify and plays it, or asks
+// which record it is
+func scanCandidates(w http.ResponseWriter, candidates []vision.Candidate, b *bundle.Bundle, client *spotify.Client) {
+	// we're not sure unless both the recognition and the Spotify match are
+	// good, then the user picks from the best few
+	var choices []spotify.Match
+	best := candidates[0]
+	if skipImageSearch || best.Score >= confidenceThreshold() {
+		matches, err := searchSpotify(client, best)
+		if wait, ok := spotify.IsRateLimited(err); ok {
+			retryLater(w, pendingScan{candidates: candidates, bundle: b, client: client}, retryPath, wait)
+			return
+		}
+		if err != nil {
+			showSpotifyError(w, client, err)
+			return
+		}
+		if matches[0].Score >= matchThreshold() {
+			play(w, client, matches[0].Album, b)
+			return
+		}
+		log.Printf("best Spotify match scored %v, asking which record it is", matches[0].Score)
+		choices = matches
+	} else {
+		log.Printf("best candidate scored %v, asking which record it is", best.Score)
+		for _, candidate := range candidates {
+			matches, err := searchSpotify(client, candidate)
+			if wait, ok := spotify.IsRateLimited(err); ok {
+				retryLater(w, pendingScan{candidates: candidates, bundle: b, client: client}, retryPath, wait)
+				return
+			}
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			choices = append(choices, matches[0])
+		}
+	}
+
+	albums := []spotify.Album{}
+	seen := map[string]bool{}
+	for _, choice := range choices {
+		if len(albums) == maxChoices {
+			break
+		}
+		if seen[choice.Album.URI] {
+			continue
+		}
+		seen[choice.Album.URI] = true
+		albums = append(albums, choice.Album)
+	}
+
+	if len(albums) == 0 {
+		web.Show(w, web.Page{Title: errorText})
+		return
+	}
+
+	id := addPending(pendingScan{albums: albums, bundle: b, client: client})
+
+	items := []web.Item{}
+	for _, album := range albums {
+		items = append(items, web.Item{
+			Text:  albumText(album),
+			Path:  choosePath(id, album.URI),
+			Image: album.ImageURL(),
+		})
+	}
+
+	web.Show(w, web.Page{
+		Title:      "Which record is it?",
+		Questions:  items,
+		ShowButton: true,
+	})
+}
+
+func chooseHandler(w http.ResponseWriter, req *http.Request) {
+	scan, uri := req.URL.Query().Get("scan"), req.URL.Query().Get("uri")
+	p := takePending(scan)
+
+	for _, album := range p.albums {
+		if album.URI == uri {
+			play(w, p.client, album, p.bundle)
+			return
+		}
+	}
+
+	log.Println("no pending scan for", scan, uri)
+	http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+}
+
+// retryHandler carries on with a scan that Spotify rate limited
+func retryHandler(w http.ResponseWriter, req *http.Request) {
+	scan := req.URL.Query().Get("scan")
+	p := takePending(scan)
+	if p.client == nil || len(p.candidates) == 0 {
+		log.Println("no pending scan for", scan)
+		http.Redirect(w, req, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	scanCandidates(w, p.candidates, p.bundle, p.client)
+}
+
+// choosePath plays the album picked from a pending scan
+func choosePath(id, albumURI string) string {
+	return "/do/choose?" + url.Values{"scan": []string{id}, "uri": []string{albumURI}}.Encode()
+}
+
+// retryPath carries on with a pending scan's candidates
+func retryPath(id string) string {
+	return "/do/retry?" + url.Values{"scan": []string{id}}.Encode()
+}
+
+// retryLater keeps the scan and tells the user we're waiting on Spotify, the
+// page carries on with it at path(id) once the wait is over
+func retryLater(w http.ResponseWriter, p pendingScan, path func(id string) string, wait time.Duration) {
+	id := addPending(p)
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	web.Show(w, web.Page{
+		Title:   "Hold on...",
+		Alerts:  []web.Alert{{Level: "warning", Text: fmt.Sprintf("Spotify is rate limiting us, retrying in %vs.", seconds)}},
+		Refresh: &web.Refresh{URL: path(id), Seconds: seconds},
+	})
+}
+
+func bundleDir() string {
+	if dir := config.Get(configBundleDir); dir != "" {
+		return dir
+	}
+	return defaultBundleDir
+}
+
+// searchSpotify returns the albums found for the candidate, best first
+func searchSpotify(client *spotify.Client, candidate vision.Candidate) ([]spotify.Match, error) {
+	if skipSpotifySearch {
+		return []spotify.Match{{Album: spotify.Album{URI: "spotify:album:6ZG5lRT77aJ3btmArcykra"}, Score: 1}}, nil
+	}
+
+	matches, err := client.SearchAlbums(spotify.Query{
+		Artist: candidate.Artist,
+		Album:  candidate.Album,
+		Text:   candidate.Text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Spotify result: %v (%.2f)", matches[0].Album.URI, matches[0].Score)
+	return matches, nil
+}
+
+// play starts the album, b is the scan's bundle if it has one
+func play(w http.ResponseWriter, client *spotify.Client, album spotify.Album, b *bundle.Bundle) {
+	// scanning the album that's playing means the record has been turned over
+	state, ok, err := client.Playback()
+	if err != nil {
+		log.Println("unable to check what's playing:", err)
+	} else if ok && state.IsPlaying && state.ContextURI() == album.URI {
+		showFlipped(w, client, album, state)
+		return
+	} else if ok && state.IsPlaying && playMode() == playModeQueue {
+		queue(w, client, album, b)
+		return
+	} else if ok && state.ContextURI() != album.URI && !replaying(b) {
+		rememberPlayback(client, state)
+	}
+
+	request := spotify.PlayRequest{URI: album.URI}
+	position, saved := resume.Get(client.Profile, album.URI)
+	resumed := saved && resumeMode() == resumeModeResume
+	if resumed {
+		log.Println("resuming from", position.TrackName)
+		request = resumeRequest(album.URI, position)
+	}
+
+	err = client.Play(request)
+	if wait, ok := spotify.IsRateLimited(err); ok {
+		p := pendingScan{albums: []spotify.Album{album}, bundle: b, client: client}
+		retryLater(w, p, func(id string) string { return choosePath(id, album.URI) }, wait)
+		return
+	}
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	var alerts []web.Alert
+	if !replaying(b) {
+		history.Add(history.Play{
+			URI:     album.URI,
+			Artists: album.ArtistNames(),
+			Album:   album.Name,
+		})
+		alerts = saveScan(client, album)
+		if !resumed {
+			// it's playing from the top, the old position is only offered below
+			resume.Forget(client.Profile, album.URI)
+		}
+	}
+
+	items := []web.Item{}
+	starts, tracks, err := albumSides(client, album.URI)
+	if err != nil {
+		log.Println("unable to find the album's sides:", err)
+	} else {
+		items = sideItems(client, album.URI, starts, tracks, 0)
+	}
+	if item, ok := resumeItem(client, album.URI, position, saved, resumed); ok {
+		items = append([]web.Item{item}, items...)
+	}
+
+	page := web.Page{
+		Title:      fmt.Sprintln("Found: ", albumText(album)),
+		Alerts:     alerts,
+		Questions:  items,
+		ShowButton: true,
+	}
+	playing, err := nowPlaying(client)
+	if err != nil {
+		log.Println("unable to show what's playing:", err)
+	} else {
+		page.NowPlaying = &playing
+	}
+	web.Show(w, page)
+}
+
+// showSpotifyError shows the error page, or asks to set up Spotify again if
+// our access was revoked
+func showSpotifyError(w http.ResponseWriter, client *spotify.Client, err error) {
+	log.Println(err)
+	if !client.IsAuthed() {
+		web.Show(w, web.Page{
+			Title:     fmt.Sprintf("We've lost access to %v's Spotify...", client.Profile),
+			Questions: []web.Item{{Text: spotifyAuthText, Path: "/spotify/login?" + profileQuery(client)}},
+		})
+		return
+	}
+
+	if wait, ok := spotify.IsRateLimited(err); ok {
+		web.Show(w, web.Page{
+			Title:      "Hold on...",
+			Alerts:     []web.Alert{{Level: "warning", Text: fmt.Sprintf("Spotify is rate limiting us, try again in %vs.", int(math.Ceil(wait.Seconds())))}},
+			ShowButton: true,
+		})
+		return
+	}
+
+	switch err {
+	case spotify.ErrPlayerUnavailable:
+		web.Show(w, web.Page{
+			Title:     "Your speaker isn't answering...",
+			Alerts:    []web.Alert{{Level: "warning", Text: "We tried to wake it up but Spotify can't find it. Check it's switched on and connected, or choose another player."}},
+			Questions: []web.Item{{Text: spotifyPlayerText, Path: "/spotify/player/options?" + profileQuery(client)}},
+		})
+		return
+	case spotify.ErrNotStarted:
+		web.Show(w, web.Page{
+			Title:      "Your speaker didn't start playing...",
+			Alerts:     []web.Alert{{Level: "warning", Text: "Spotify took the album but the speaker isn't playing it. It may still be waking up, try scanning again."}},
+			ShowButton: true,
+		})
+		return
+	}
+	web.Show(w, web.Page{Title: errorText})
+}
+
+func albumText(album spotify.Album) string {
+	if album.Name == "" {
+		return album.URI
+	}
+	if len(album.Artists) == 0 {
+		return album.Name
+	}
+	return fmt.Sprintf("%v by %v", album.Name, strings.Join(album.ArtistNames(), ", "))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/bundle"
+	"github.com/jccroft1/autorecord/internal/spotify"
+)
+
+// testStore is an in memory spotify.TokenStore
+type testStore map[string]string
+
+func (s testStore) Get(key string) string { return s[key] }
+func (s testStore) Set(key, value string) { s[key] = value }
+
+// networkTransport sends every request to a test server instead
+type networkTransport struct {
+	url  *url.URL
+	base http.RoundTripper
+}
+
+func (t networkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme, r.URL.Host = t.url.Scheme, t.url.Host
+	return t.base.RoundTrip(r)
+}
+
+// TestReplay plays a scan of Parachutes recorded against fake Google and
+// Spotify servers. Nothing may reach the network or be written locally.
+func TestReplay(t *testing.T) {
+	network := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("replay reached the network: %v %v", req.Method, req.URL)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer network.Close()
+
+	networkURL, err := url.Parse(network.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = networkTransport{url: networkURL, base: defaultTransport}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	t.Setenv("AR_VISION_URL", "")
+	t.Setenv("AR_API_KEY", "")
+
+	b, err := bundle.Replay(filepath.Join("testdata", "bundles", "parachutes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := b.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// local state such as the history is written relative to the working
+	// directory
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	store := testStore{
+		"spotify_player":      "kitchen-1",
+		"spotify_player_name": "Kitchen",
+		"spotify_player_type": "Speaker",
+	}
+	client := spotify.NewClient(store)
+	client.Profile = spotify.DefaultProfile
+
+	w := httptest.NewRecorder()
+	scan(w, image, b, scanClient(client, b))
+
+	body := w.Body.String()
+	for _, want := range []string{"Found:  Parachutes by Coldplay", "Play side B, starting with"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page doesn't contain %q:\n%v", want, body)
+		}
+	}
+
+	if len(store) != 3 {
+		t.Errorf("replay changed the account's store: %v", store)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Errorf("replay wrote %v", f.Name())
+	}
+}
+
+// TestRateLimitedPlay follows the page shown while Spotify rate limits the
+// play, which has to carry on with the same album
+func TestRateLimitedPlay(t *testing.T) {
+	plays := 0
+	started := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/me/player":
+			if !started {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Write([]byte(`{"is_playing":true,"context":{"uri":"spotify:album:parachutes","type":"album"}}`))
+		case "/v1/me/player/devices":
+			w.Write([]byte(`{"devices":[{"id":"kitchen-1","name":"Kitchen","type":"Speaker"}]}`))
+		case "/v1/me/player/play":
+			plays++
+			if plays == 1 {
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			started = true
+			w.WriteHeader(http.StatusNoContent)
+		case "/v1/albums/parachutes/tracks":
+			w.Write([]byte(`{"items":[
+				{"uri":"spotify:track:one","name":"Don't Panic","track_number":1,"disc_number":1,"duration_ms":137000},
+				{"uri":"spotify:track:two","name":"Shiver","track_number":2,"disc_number":1,"duration_ms":300000}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	// the history and other local state are written to the working directory
+	t.Chdir(t.TempDir())
+
+	store := testStore{
+		"spotify_access_token": "access",
+		"spotify_expiry":       time.Now().Add(time.Hour).Format("2006-01-02 15:04:05"),
+		"spotify_player":       "kitchen-1",
+		"spotify_player_name":  "Kitchen",
+		"spotify_player_type":  "Speaker",
+	}
+	client := spotify.NewClient(store)
+	client.Profile = spotify.DefaultProfile
+	client.APIURL = srv.URL + "/v1"
+	client.HTTPClient = srv.Client()
+
+	album := spotify.Album{URI: "spotify:album:parachutes", Name: "Parachutes", Artists: []spotify.Artist{{Name: "Coldplay"}}}
+	w := httptest.NewRecorder()
+	play(w, client, album, nil)
+
+	refresh := regexp.MustCompile(`content="60; url=([^"]+)"`).FindStringSubmatch(w.Body.String())
+	if refresh == nil {
+		t.Fatalf("page doesn't wait for Spotify:\n%v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	chooseHandler(w, httptest.NewRequest("GET", html.UnescapeString(refresh[1]), nil))
+	if plays != 2 {
+		t.Errorf("album was played %v times", plays)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Found:  Parachutes by Coldplay") {
+		t.Errorf("scan didn't carry on:\n%v", body)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jccroft1/autorecord/internal/resume"
+	"github.com/jccroft1/autorecord/internal/sides"
+	"github.com/jccroft1/autorecord/internal/spotify"
+	"github.com/jccroft1/autorecord/internal/web"
+)
+
+// albumSides returns where each of the album's sides starts and its tracks,
+// the sides are guessed from the track lengths unless they've been set
+func albumSides(client *spotify.Client, albumURI string) ([]int, []spotify.Track, error) {
+	tracks, err := client.AlbumTracks(albumURI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	starts, ok := sides.Get(albumURI)
+	if !ok {
+		starts = sides.Guess(tracks)
+	}
+	return starts, tracks, nil
+}
+
+// sideItems offers to play each side of the album, playing is the side that
+// is on now or -1
+func sideItems(client *spotify.Client, albumURI string, starts []int, tracks []spotify.Track, playing int) []web.Item {
+	if len(starts) < 2 {
+		return []web.Item{}
+	}
+
+	items := []web.Item{}
+	for side, start := range starts {
+		if side == playing || start >= len(tracks) {
+			continue
+		}
+		items = append(items, web.Item{
+			Text: fmt.Sprintf("Play side %v, starting with %v", sides.Name(side), tracks[start].Name),
+			Path: fmt.Sprintf("/do/side?album=%v&side=%v&%v", url.QueryEscape(albumURI), sides.Name(side), profileQuery(client)),
+		})
+	}
+	items = append(items, web.Item{
+		Text: "Sides wrong? Set where each one starts.",
+		Path: fmt.Sprintf("/settings/sides?album=%v&%v", url.QueryEscape(albumURI), profileQuery(client)),
+	})
+	return items
+}
+
+// showFlipped asks which side to play when the album scanned is already
+// playing, the record has most likely been turned over
+func showFlipped(w http.ResponseWriter, client *spotify.Client, album spotify.Album, state spotify.PlaybackState) {
+	starts, tracks, err := albumSides(client, album.URI)
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	playing := -1
+	if state.Item != nil {
+		for i, track := range tracks {
+			if track.URI == state.Item.URI {
+				playing = sides.Of(starts, i)
+			}
+		}
+	}
+
+	web.Show(w, web.Page{
+		Title:      fmt.Sprintf("Already playing %v", albumText(album)),
+		Questions:  sideItems(client, album.URI, starts, tracks, playing),
+		ShowButton: true,
+	})
+}
+
+// sideHandler plays a side of an album, ?side= takes a letter or "next" and
+// ?album= defaults to the album that's playing, so a second trigger can flip
+// the record with /do/side
+func sideHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	state, ok, err := client.Playback()
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	albumURI := req.FormValue("album")
+	if albumURI == "" {
+		albumURI = state.ContextURI()
+	}
+	if albumURI == "" {
+		web.Show(w, web.Page{Title: "Nothing is playing, scan a record first.", ShowButton: true})
+		return
+	}
+	if !spotify.IsAlbumURI(albumURI) {
+		web.Show(w, web.Page{Title: "That isn't an album.", ShowButton: true})
+		return
+	}
+
+	starts, tracks, err := albumSides(client, albumURI)
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	side, found := sides.Parse(req.FormValue("side"))
+	if !found {
+		// the side after the one playing, back to the start after the last
+		side = 0
+		if ok && state.ContextURI() == albumURI && state.Item != nil {
+			for i, track := range tracks {
+				if track.URI == state.Item.URI {
+					side = (sides.Of(starts, i) + 1) % len(starts)
+				}
+			}
+		}
+	}
+	if side >= len(starts) {
+		web.Show(w, web.Page{Title: fmt.Sprintf("This record doesn't have a side %v.", sides.Name(side)), ShowButton: true})
+		return
+	}
+
+	if ok && state.ContextURI() != albumURI {
+		rememberPlayback(client, state)
+	}
+
+	err = client.Play(spotify.PlayRequest{URI: albumURI, Offset: spotify.PositionOffset(starts[side])})
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+	if starts[side] == 0 {
+		// started again from the top, there's nothing to carry on from
+		resume.Forget(client.Profile, albumURI)
+	}
+
+	web.Show(w, web.Page{
+		Title:      fmt.Sprintf("Playing side %v", sides.Name(side)),
+		Questions:  sideItems(client, albumURI, starts, tracks, side),
+		ShowButton: true,
+	})
+}
+
+// sidesSettings sets where each side of an album starts
+func sidesSettings(w http.ResponseWriter, req *http.Request) {
+	client, err := spotifyClient(req)
+	if err != nil {
+		log.Println(err)
+		web.Show(w, web.Page{Title: errorText, Alerts: []web.Alert{{Level: "danger", Text: err.Error()}}})
+		return
+	}
+
+	albumURI := req.FormValue("album")
+	if !spotify.IsAlbumURI(albumURI) {
+		web.Show(w, web.Page{Title: "That isn't an album.", ShowButton: true})
+		return
+	}
+
+	alerts := []web.Alert{}
+
+	if req.Method == http.MethodPost {
+		starts := []int{}
+		var err error
+		for _, field := range strings.FieldsFunc(req.FormValue("starts"), func(r rune) bool { return r == ',' || r == ' ' }) {
+			number, convErr := strconv.Atoi(field)
+			if convErr != nil {
+				err = fmt.Errorf("%v isn't a track number", field)
+				break
+			}
+			starts = append(starts, number-1)
+		}
+		if err == nil {
+			err = sides.Set(albumURI, starts)
+		}
+
+		if err != nil {
+			alerts = append(alerts, web.Alert{Level: "danger", Text: err.Error()})
+		} else {
+			alerts = append(alerts, web.Alert{Level: "success", Text: "Sides saved."})
+		}
+	}
+
+	starts, tracks, err := albumSides(client, albumURI)
+	if err != nil {
+		showSpotifyError(w, client, err)
+		return
+	}
+
+	numbers := []string{}
+	for _, start := range starts {
+		numbers = append(numbers, strconv.Itoa(start+1))
+	}
+	names := []string{}
+	for i, track := range tracks {
+		names = append(names, fmt.Sprintf("%v. %v", i+1, track.Name))
+	}
+	alerts = append(alerts, web.Alert{Level: "info", Text: strings.Join(names, " / ")})
+
+	web.Show(w, web.Page{
+		Title:  "Where does each side start?",
+		Alerts: alerts,
+		Form: &web.Form{
+			Action: fmt.Sprintf("/settings/sides?album=%v&%v", url.QueryEscape(albumURI), profileQuery(client)),
+			Fields: []web.Field{
+				{Label: "Track number each side starts on, e.g. 1, 6 (leave blank to guess from the track lengths)", Name: "starts", Value: strings.Join(numbers, ", ")},
+			},
+		},
+	})
+}
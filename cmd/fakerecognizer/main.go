@@ -0,0 +1,95 @@
+// Command fakerecognizer is a reference server for the HTTP recognizer
+// protocol, see internal/vision/http.go. It returns canned answers so the
+// rest of autorecord can be tested without Google Vision or a real model.
+//
+// Answers are read from a JSON file mapping the SHA-256 of the base64 encoded
+// image to its candidates, with "*" used for any other image:
+//
+//	{
+//	  "*": [{"artist": "Coldplay", "album": "Parachutes", "score": 0.93}]
+//	}
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/jccroft1/autorecord/internal/vision"
+)
+
+const anyImage = "*"
+
+var (
+	addr    = flag.String("addr", ":8090", "address to listen on")
+	answers = flag.String("answers", "", "JSON file of canned answers by image hash")
+
+	canned = map[string][]vision.Candidate{
+		anyImage: {
+			{Artist: "Coldplay", Album: "Parachutes", Score: 0.93},
+			{Text: "coldplay parachutes vinyl", Score: 0.4},
+		},
+	}
+)
+
+func main() {
+	flag.Parse()
+
+	if *answers != "" {
+		contents, err := ioutil.ReadFile(*answers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		canned = map[string][]vision.Candidate{}
+		err = json.Unmarshal(contents, &canned)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	http.HandleFunc("/", recognizeHandler)
+
+	log.Println("starting fake recognizer on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func recognizeHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(vision.RecognizeResponse{Error: "POST an image"})
+		return
+	}
+
+	var request vision.RecognizeRequest
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(vision.RecognizeResponse{Error: err.Error()})
+		return
+	}
+
+	// an empty image is a connection check
+	response := vision.RecognizeResponse{Candidates: []vision.Candidate{}}
+	if request.Image != "" {
+		sum := sha256.Sum256([]byte(request.Image))
+		key := hex.EncodeToString(sum[:])
+
+		candidates, ok := canned[key]
+		if !ok {
+			candidates = canned[anyImage]
+		}
+		if request.MaxResults > 0 && len(candidates) > request.MaxResults {
+			candidates = candidates[:request.MaxResults]
+		}
+		response.Candidates = candidates
+		log.Println("answered", key, "with", len(response.Candidates), "candidates")
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
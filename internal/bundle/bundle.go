@@ -0,0 +1,296 @@
+// Package bundle records every external call a scan makes, along with the
+// captured image, into a directory so the scan can be replayed later without
+// a network.
+//
+// Each scan sends its requests through its own bundle's Client, so only that
+// scan's calls are recorded or replayed.
+//
+// Secrets are removed from recordings: API keys and client secrets in URLs,
+// secrets, codes and tokens in token requests and access, refresh and ID
+// tokens in responses.
+package bundle
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	imageFile    = "image.png"
+	exchangeGlob = "exchange-*.json"
+	exchangeName = "exchange-%03d.json"
+	redacted     = "redacted"
+)
+
+var (
+	secretParams = []string{"key", "client_secret"}
+	secretFields = []string{"access_token", "refresh_token", "id_token"}
+)
+
+// Exchange is one recorded request and its response
+type Exchange struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"request_body,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	ResponseBody string      `json:"response_body"`
+
+	used bool
+}
+
+// Bundle is a directory of a scan's image and exchanges
+type Bundle struct {
+	Dir       string
+	replaying bool
+	exchanges []*Exchange
+	sync.Mutex
+}
+
+// Record starts recording into a new bundle directory
+func Record(dir string) (*Bundle, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bundle{Dir: dir}, nil
+}
+
+// Replay loads a bundle and starts answering requests from it
+func Replay(dir string) (*Bundle, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, exchangeGlob))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, imageFile)); err != nil {
+		return nil, fmt.Errorf("%v is not a bundle: %v", dir, err)
+	}
+	sort.Strings(paths)
+
+	b := &Bundle{Dir: dir, replaying: true}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var e Exchange
+		err = json.Unmarshal(contents, &e)
+		if err != nil {
+			return nil, fmt.Errorf("bad exchange %v: %v", path, err)
+		}
+		b.exchanges = append(b.exchanges, &e)
+	}
+
+	return b, nil
+}
+
+// Client returns an HTTP client that records its requests into the bundle, or
+// answers them from it when replaying
+func (b *Bundle) Client() *http.Client {
+	return &http.Client{Transport: &Transport{Bundle: b}}
+}
+
+// Replaying reports whether the bundle answers requests instead of the network
+func (b *Bundle) Replaying() bool {
+	return b.replaying
+}
+
+// SaveImage stores the base64 encoded captured image
+func (b *Bundle) SaveImage(imageData string) error {
+	data, err := base64.StdEncoding.DecodeString(imageData)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.Dir, imageFile), data, 0644)
+}
+
+// Image returns the recorded image, base64 encoded like the camera's
+func (b *Bundle) Image() (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.Dir, imageFile))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (b *Bundle) record(e *Exchange) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.exchanges = append(b.exchanges, e)
+	contents, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.Dir, fmt.Sprintf(exchangeName, len(b.exchanges))), contents, 0644)
+}
+
+// next returns the first unused exchange for the same method and URL
+func (b *Bundle) next(method, rawURL string) (*Exchange, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, e := range b.exchanges {
+		if !e.used && e.Method == method && e.URL == rawURL {
+			e.used = true
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Transport records requests into the bundle, or replays them from it. Base
+// sends recorded requests, http.DefaultTransport when nil.
+type Transport struct {
+	Bundle *Bundle
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.Bundle
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	cleanURL := redactURL(req.URL)
+
+	if b.Replaying() {
+		e, ok := b.next(req.Method, cleanURL)
+		if !ok {
+			return nil, fmt.Errorf("no recorded response for %v %v", req.Method, cleanURL)
+		}
+		return e.response(req), nil
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	err = b.record(&Exchange{
+		Method:       req.Method,
+		URL:          cleanURL,
+		RequestBody:  redactForm(string(reqBody)),
+		Status:       res.StatusCode,
+		Header:       keepHeaders(res.Header),
+		ResponseBody: redactJSON(resBody),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to record exchange: %v", err)
+	}
+
+	return res, nil
+}
+
+func (e *Exchange) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	for k, v := range e.Header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(e.ResponseBody)),
+		ContentLength: int64(len(e.ResponseBody)),
+		Request:       req,
+	}
+}
+
+func redactURL(u *url.URL) string {
+	clean := *u
+	qs := clean.Query()
+	for _, param := range secretParams {
+		// even empty ones, so a replay without the key still matches
+		if _, ok := qs[param]; ok {
+			qs.Set(param, redacted)
+		}
+	}
+	clean.RawQuery = qs.Encode()
+	return clean.String()
+}
+
+// redactForm removes secrets from form encoded request bodies, such as token
+// refreshes
+func redactForm(body string) string {
+	form, err := url.ParseQuery(body)
+	if err != nil || form.Get("grant_type") == "" {
+		return body
+	}
+	for _, param := range append(secretParams, "refresh_token", "code", "code_verifier", "assertion") {
+		if form.Get(param) != "" {
+			form.Set(param, redacted)
+		}
+	}
+	return form.Encode()
+}
+
+// redactJSON drops secret fields from JSON object responses, they are removed
+// rather than replaced so a replay can't store a fake token
+func redactJSON(body []byte) string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return string(body)
+	}
+
+	found := false
+	for _, field := range secretFields {
+		if _, ok := fields[field]; ok {
+			delete(fields, field)
+			found = true
+		}
+	}
+	if !found {
+		return string(body)
+	}
+
+	clean, err := json.Marshal(fields)
+	if err != nil {
+		return string(body)
+	}
+	return string(clean)
+}
+
+// keepHeaders keeps the response headers the app reads
+func keepHeaders(header http.Header) http.Header {
+	kept := http.Header{}
+	for _, name := range []string{"Content-Type", "Retry-After"} {
+		if v := header.Get(name); v != "" {
+			kept.Set(name, v)
+		}
+	}
+	return kept
+}
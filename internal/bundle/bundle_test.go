@@ -0,0 +1,46 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecordRedacts checks a service account token exchange leaves no secrets
+// in the bundle, which may be attached to a bug report
+func TestRecordRedacts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"live-access","id_token":"live-id","refresh_token":"live-refresh","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	b, err := Record(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"grant_type": []string{"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": []string{"live-jwt"}}
+	res, err := b.Client().PostForm(srv.URL+"/token?key=live-key", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "live-access") {
+		t.Errorf("the caller didn't get the token: %s", body)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(b.Dir, "exchange-001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"live-access", "live-id", "live-refresh", "live-jwt", "live-key"} {
+		if strings.Contains(string(contents), secret) {
+			t.Errorf("bundle contains %v:\n%s", secret, contents)
+		}
+	}
+}
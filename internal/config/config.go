@@ -26,6 +26,11 @@ func New(fileName string) *Config {
 	return &c
 }
 
+// Default returns the config stored in config.txt that the package functions use
+func Default() *Config {
+	return defaultConfig
+}
+
 func Get(key string) string {
 	return defaultConfig.Get(key)
 }
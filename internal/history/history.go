@@ -0,0 +1,86 @@
+package history
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/jsonfile"
+)
+
+// History is the list of albums we've played, oldest first, kept in a file
+type History struct {
+	FileName string
+	Plays    []Play
+	sync.Mutex
+}
+
+type Play struct {
+	URI     string    `json:"uri"`
+	Artists []string  `json:"artists"`
+	Album   string    `json:"album"`
+	Time    time.Time `json:"time"`
+}
+
+var defaultHistory = New("history.json")
+
+func New(fileName string) *History {
+	h := History{
+		FileName: fileName,
+		Plays:    []Play{},
+	}
+	err := jsonfile.Load(fileName, &h.Plays)
+	if err != nil {
+		log.Println("unable to read the history:", err)
+	}
+	return &h
+}
+
+func Add(play Play) {
+	defaultHistory.Add(play)
+}
+
+func (h *History) Add(play Play) {
+	h.Lock()
+	defer h.Unlock()
+	if play.Time.IsZero() {
+		play.Time = time.Now()
+	}
+	h.Plays = append(h.Plays, play)
+	h.flush()
+}
+
+func Artists() []string {
+	return defaultHistory.Artists()
+}
+
+// Artists returns every artist we've played, used as a dictionary when
+// parsing labels
+func (h *History) Artists() []string {
+	h.Lock()
+	defer h.Unlock()
+
+	seen := map[string]bool{}
+	artists := []string{}
+	for _, play := range h.Plays {
+		for _, artist := range play.Artists {
+			key := strings.ToLower(artist)
+			if artist == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			artists = append(artists, artist)
+		}
+	}
+	sort.Strings(artists)
+	return artists
+}
+
+func (h *History) flush() {
+	err := jsonfile.Save(h.FileName, h.Plays)
+	if err != nil {
+		log.Println("unable to save the history:", err)
+	}
+}
@@ -0,0 +1,31 @@
+// Package jsonfile keeps the small stores, like the history, in JSON files.
+// Like config they read their file once at startup and write it again after
+// every change.
+package jsonfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Load reads the file into v, a missing file leaves v as it is
+func Load(fileName string, v interface{}) error {
+	contents, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+// Save writes v to the file, indented so it can be read and edited by hand
+func Save(fileName string, v interface{}) error {
+	contents, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, contents, 0644)
+}
@@ -0,0 +1,96 @@
+package resume
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/jsonfile"
+	"github.com/jccroft1/autorecord/internal/spotify"
+)
+
+// Resume keeps where each profile stopped each album, so it can carry on from
+// there next time. Albums are kept by profile and album URI, the default
+// profile's by album URI alone as they were before there were profiles.
+type Resume struct {
+	FileName string
+	Albums   map[string]Position
+	sync.Mutex
+}
+
+// Position is the track we stopped on and how far into it
+type Position struct {
+	TrackURI   string    `json:"track_uri"`
+	TrackName  string    `json:"track_name"`
+	ProgressMS int       `json:"progress_ms"`
+	Time       time.Time `json:"time"`
+}
+
+var defaultResume = New("resume.json")
+
+func New(fileName string) *Resume {
+	r := Resume{
+		FileName: fileName,
+		Albums:   make(map[string]Position),
+	}
+	err := jsonfile.Load(fileName, &r.Albums)
+	if err != nil {
+		log.Println("unable to read the resume positions:", err)
+	}
+	return &r
+}
+
+// key is where the profile's position for the album is kept
+func key(profile, uri string) string {
+	if profile == "" || profile == spotify.DefaultProfile {
+		return uri
+	}
+	return profile + "|" + uri
+}
+
+func Get(profile, uri string) (Position, bool) {
+	return defaultResume.Get(profile, uri)
+}
+
+func (r *Resume) Get(profile, uri string) (Position, bool) {
+	r.Lock()
+	defer r.Unlock()
+	position, ok := r.Albums[key(profile, uri)]
+	return position, ok
+}
+
+func Set(profile, uri string, position Position) {
+	defaultResume.Set(profile, uri, position)
+}
+
+func (r *Resume) Set(profile, uri string, position Position) {
+	r.Lock()
+	defer r.Unlock()
+	if position.Time.IsZero() {
+		position.Time = time.Now()
+	}
+	r.Albums[key(profile, uri)] = position
+	r.flush()
+}
+
+func Forget(profile, uri string) {
+	defaultResume.Forget(profile, uri)
+}
+
+// Forget drops the album's position, e.g. once it has been played to the end
+func (r *Resume) Forget(profile, uri string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.Albums[key(profile, uri)]; !ok {
+		return
+	}
+	delete(r.Albums, key(profile, uri))
+	r.flush()
+}
+
+func (r *Resume) flush() {
+	err := jsonfile.Save(r.FileName, r.Albums)
+	if err != nil {
+		log.Println("unable to save the resume positions:", err)
+	}
+}
@@ -0,0 +1,28 @@
+package resume
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	r := New(filepath.Join(t.TempDir(), "resume.json"))
+	r.Set("default", "spotify:album:a", Position{TrackName: "Shiver"})
+	r.Set("kid", "spotify:album:a", Position{TrackName: "Yellow"})
+
+	if p, ok := r.Get("", "spotify:album:a"); !ok || p.TrackName != "Shiver" {
+		t.Errorf("default profile has %v", p)
+	}
+	r.Forget("kid", "spotify:album:a")
+	if _, ok := r.Get("kid", "spotify:album:a"); ok {
+		t.Errorf("kid's position wasn't forgotten")
+	}
+	if _, ok := r.Get("default", "spotify:album:a"); !ok {
+		t.Errorf("forgetting kid's position forgot the default profile's")
+	}
+
+	// positions from before profiles belong to the default profile
+	if _, ok := New(r.FileName).Albums["spotify:album:a"]; !ok {
+		t.Errorf("default profile's position isn't kept by album URI")
+	}
+}
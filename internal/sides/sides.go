@@ -0,0 +1,136 @@
+package sides
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jccroft1/autorecord/internal/jsonfile"
+	"github.com/jccroft1/autorecord/internal/spotify"
+)
+
+// Sides keeps where each side of our records starts, by album URI, in a file.
+//
+// A side is stored as the position of its first track in the album's track
+// list, counting from 0, so the first side always starts at 0.
+type Sides struct {
+	FileName string
+	Albums   map[string][]int
+	sync.Mutex
+}
+
+var defaultSides = New("sides.json")
+
+func New(fileName string) *Sides {
+	s := Sides{
+		FileName: fileName,
+		Albums:   make(map[string][]int),
+	}
+	err := jsonfile.Load(fileName, &s.Albums)
+	if err != nil {
+		log.Println("unable to read the sides:", err)
+	}
+	return &s
+}
+
+func Get(uri string) ([]int, bool) {
+	return defaultSides.Get(uri)
+}
+
+// Get returns the album's side starts if they've been set
+func (s *Sides) Get(uri string) ([]int, bool) {
+	s.Lock()
+	defer s.Unlock()
+	starts, ok := s.Albums[uri]
+	return starts, ok
+}
+
+func Set(uri string, starts []int) error {
+	return defaultSides.Set(uri, starts)
+}
+
+// Set stores the album's side starts, no starts forgets them so they're
+// guessed again
+func (s *Sides) Set(uri string, starts []int) error {
+	if len(starts) > 0 && starts[0] != 0 {
+		return fmt.Errorf("the first side has to start at the first track")
+	}
+	for i := 1; i < len(starts); i++ {
+		if starts[i] <= starts[i-1] {
+			return fmt.Errorf("each side has to start after the one before")
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if len(starts) == 0 {
+		delete(s.Albums, uri)
+	} else {
+		s.Albums[uri] = starts
+	}
+	s.flush()
+	return nil
+}
+
+// Guess splits each disc into two sides of about the same length, which is
+// how most records are cut
+func Guess(tracks []spotify.Track) []int {
+	starts := []int{}
+	for start := 0; start < len(tracks); {
+		end := start
+		total := 0
+		for end < len(tracks) && tracks[end].DiscNumber == tracks[start].DiscNumber {
+			total += tracks[end].DurationMS
+			end++
+		}
+
+		starts = append(starts, start)
+		played := 0
+		for i := start; i < end-1; i++ {
+			played += tracks[i].DurationMS
+			if played*2 >= total {
+				starts = append(starts, i+1)
+				break
+			}
+		}
+		start = end
+	}
+	return starts
+}
+
+// Name returns the side's letter, A for the first
+func Name(side int) string {
+	if side < 0 || side >= 26 {
+		return strconv.Itoa(side + 1)
+	}
+	return string(rune('A' + side))
+}
+
+// Parse returns the side for a letter like "b"
+func Parse(name string) (int, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if len(name) != 1 || name[0] < 'A' || name[0] > 'Z' {
+		return 0, false
+	}
+	return int(name[0] - 'A'), true
+}
+
+// Of returns the side the track position is on
+func Of(starts []int, position int) int {
+	side := 0
+	for i, start := range starts {
+		if position >= start {
+			side = i
+		}
+	}
+	return side
+}
+
+func (s *Sides) flush() {
+	err := jsonfile.Save(s.FileName, s.Albums)
+	if err != nil {
+		log.Println("unable to save the sides:", err)
+	}
+}
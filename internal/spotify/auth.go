@@ -0,0 +1,366 @@
+package spotify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logins have to be finished within this long of starting them
+const authAttemptExpiry = 10 * time.Minute
+
+var requiredScopes = []string{
+	"user-modify-playback-state", // Start or resume playback
+	"user-read-playback-state",   // Get Players
+	"user-read-private",          // Search for an item
+	"user-library-modify",        // Save albums to the library
+	"playlist-read-private",      // Find the session playlist
+	"playlist-modify-private",    // Add albums to the session playlist
+}
+
+// authAttempt is one login started by GetAuthURL, keyed by its state
+type authAttempt struct {
+	verifier    string
+	redirectURL string
+	expiry      time.Time
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	// TokenType string `json:"token_type"`
+	Scope        string `json:"scope"`
+	Expiry       int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshResponse struct {
+	AccessToken string `json:"access_token"`
+	// TokenType string `json:"token_type"`
+	Scope        string `json:"scope"`
+	Expiry       int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenError is the error the accounts service returns from the token endpoint
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Description)
+}
+
+// MissingScopes returns the permissions the account hasn't granted, logins from
+// before a permission was needed have to be done again
+func (c *Client) MissingScopes() []string {
+	granted := map[string]bool{}
+	for _, scope := range strings.Fields(c.Store.Get(configScope)) {
+		granted[scope] = true
+	}
+
+	missing := []string{}
+	for _, scope := range requiredScopes {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// UsesPKCE reports whether logins use a PKCE code verifier rather than the
+// client secret, which is the case when no secret is set
+func (c *Client) UsesPKCE() bool {
+	return c.ClientSecret == ""
+}
+
+func (c *Client) ProcessCallback(qs url.Values) error {
+	attempt, ok := c.takeAttempt(qs.Get("state"))
+	if !ok {
+		return fmt.Errorf("state validation failed, the login is unknown or expired")
+	}
+
+	// extract query string stuff
+	code := qs.Get("code")
+	if code == "" {
+		return fmt.Errorf("Failed to get code: %v", qs.Get("error"))
+	}
+
+	return c.finishLogin(code, attempt)
+}
+
+// finishLogin exchanges the login's code for tokens and stores them
+func (c *Client) finishLogin(code string, attempt authAttempt) error {
+	requestTime := time.Now()
+	tokenData, err := c.getAuthToken(code, attempt)
+	if err != nil {
+		return err
+	}
+	if tokenData.AccessToken == "" || tokenData.RefreshToken == "" {
+		return fmt.Errorf("spotify tokens not returned")
+	}
+	c.Store.Set(configExpiry, requestTime.Add(time.Duration(tokenData.Expiry)*time.Second).Format(defaultTimeFormat))
+	c.Store.Set(configAccessToken, tokenData.AccessToken)
+	c.Store.Set(configRefreshToken, tokenData.RefreshToken)
+	c.Store.Set(configScope, tokenData.Scope)
+
+	return nil
+}
+
+func (c *Client) GetAuthURL() (string, error) {
+	if !c.HasCredentials() {
+		return "", fmt.Errorf("Failed to get Spotify client ID from environment")
+	}
+
+	state, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	attempt := authAttempt{
+		redirectURL: c.RedirectURL(),
+		expiry:      time.Now().Add(authAttemptExpiry),
+	}
+
+	v := url.Values{
+		"response_type": []string{"code"},
+		"client_id":     []string{c.ClientID},
+		"scope":         []string{strings.Join(requiredScopes, " ")},
+		"redirect_uri":  []string{attempt.redirectURL},
+		"state":         []string{state},
+	}
+
+	if c.UsesPKCE() {
+		attempt.verifier, err = randomString(32)
+		if err != nil {
+			return "", err
+		}
+		challenge := sha256.Sum256([]byte(attempt.verifier))
+		v.Set("code_challenge_method", "S256")
+		v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	}
+
+	c.addAttempt(state, attempt)
+	return c.AccountsURL + fmt.Sprintf(authPath, v.Encode()), nil
+}
+
+func (c *Client) addAttempt(state string, attempt authAttempt) {
+	c.attemptLock.Lock()
+	defer c.attemptLock.Unlock()
+
+	if c.attempts == nil {
+		c.attempts = make(map[string]authAttempt)
+	}
+	for s, a := range c.attempts {
+		if time.Now().After(a.expiry) {
+			delete(c.attempts, s)
+		}
+	}
+	c.attempts[state] = attempt
+}
+
+// takeAttempt returns the login for the state, each can only be used once
+func (c *Client) takeAttempt(state string) (authAttempt, bool) {
+	c.attemptLock.Lock()
+	defer c.attemptLock.Unlock()
+
+	attempt, ok := c.attempts[state]
+	if !ok || state == "" {
+		return authAttempt{}, false
+	}
+	delete(c.attempts, state)
+
+	if time.Now().After(attempt.expiry) {
+		return authAttempt{}, false
+	}
+	return attempt, true
+}
+
+func (c *Client) hasAttempt(state string) bool {
+	c.attemptLock.Lock()
+	defer c.attemptLock.Unlock()
+	_, ok := c.attempts[state]
+	return ok && state != ""
+}
+
+// ProcessPasted finishes a login from the address the user was redirected to,
+// or just the code from it, pasted back into the app. This lets the login
+// happen on another device when the redirect URI is a loopback address that
+// only exists there.
+func (c *Client) ProcessPasted(input string) error {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return fmt.Errorf("nothing was pasted")
+	}
+
+	if strings.Contains(input, "?") || strings.Contains(input, "code=") {
+		query := input
+		if i := strings.Index(input, "?"); i >= 0 {
+			query = input[i+1:]
+		}
+		qs, err := url.ParseQuery(query)
+		if err != nil {
+			return fmt.Errorf("unable to read the pasted address: %v", err)
+		}
+		return c.ProcessCallback(qs)
+	}
+
+	// only the code was pasted, it could be from any login still open, e.g.
+	// the page was reloaded after scanning its QR code. Try the newest first,
+	// logins the code doesn't belong to are kept for their own codes.
+	err := fmt.Errorf("the login is unknown or expired")
+	for _, state := range c.openAttempts() {
+		attempt, ok := c.takeAttempt(state)
+		if !ok {
+			continue
+		}
+		err = c.finishLogin(input, attempt)
+		if err == nil {
+			return nil
+		}
+		c.addAttempt(state, attempt)
+	}
+	return err
+}
+
+// openAttempts returns the states of the logins that haven't expired, newest
+// first
+func (c *Client) openAttempts() []string {
+	c.attemptLock.Lock()
+	defer c.attemptLock.Unlock()
+
+	states := []string{}
+	for state, attempt := range c.attempts {
+		if time.Now().Before(attempt.expiry) {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return c.attempts[states[i]].expiry.After(c.attempts[states[j]].expiry)
+	})
+	return states
+}
+
+// RedirectURL returns where Spotify sends the user after they log in, the
+// environment takes priority over the store
+func (c *Client) RedirectURL() string {
+	if u := os.Getenv(envRedirectURL); u != "" {
+		return u
+	}
+	if u := c.Store.Get(configRedirectURL); u != "" {
+		return u
+	}
+	return DefaultRedirectURL
+}
+
+// SetRedirectURL stores the redirect URI, it must match one registered for
+// the app on the Spotify dashboard
+func (c *Client) SetRedirectURL(redirectURL string) error {
+	redirectURL = strings.TrimSpace(redirectURL)
+	if redirectURL == "" {
+		c.Store.Set(configRedirectURL, "")
+		return nil
+	}
+
+	u, err := url.Parse(redirectURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%v is not a valid redirect URI", redirectURL)
+	}
+	if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+		return fmt.Errorf("spotify only allows http redirect URIs on a loopback address like 127.0.0.1, use https otherwise")
+	}
+
+	c.Store.Set(configRedirectURL, redirectURL)
+	return nil
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// randomString returns n random bytes, URL safe base64 encoded. 32 bytes make
+// a 43 character PKCE code verifier.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (c *Client) getAuthToken(code string, attempt authAttempt) (TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", attempt.redirectURL)
+	if attempt.verifier != "" {
+		form.Set("code_verifier", attempt.verifier)
+	}
+
+	var response TokenResponse
+	err := c.getToken(form, &response)
+	return response, err
+}
+
+func (c *Client) getRefreshToken(token string) (RefreshResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", token)
+
+	var response RefreshResponse
+	err := c.getToken(form, &response)
+	return response, err
+}
+
+func (c *Client) getToken(form url.Values, data interface{}) error {
+	if c.UsesPKCE() {
+		// without a secret the client ID goes in the body instead
+		form.Set("client_id", c.ClientID)
+	}
+
+	req, err := http.NewRequest("POST", c.AccountsURL+tokenPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !c.UsesPKCE() {
+		req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	}
+	res, err := c.retryClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		tokenErr := &TokenError{}
+		if json.Unmarshal(body, tokenErr) == nil && tokenErr.Code != "" {
+			return tokenErr
+		}
+		return fmt.Errorf("bad status code response: %v %v", res.StatusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
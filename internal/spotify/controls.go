@@ -0,0 +1,63 @@
+package spotify
+
+import (
+	"net/url"
+	"strconv"
+)
+
+const (
+	pausePath    = "/me/player/pause?%v"
+	resumePath   = "/me/player/play?%v"
+	nextPath     = "/me/player/next?%v"
+	previousPath = "/me/player/previous?%v"
+	seekPath     = "/me/player/seek?%v"
+	queuePath    = "/me/player/queue?%v"
+)
+
+// Pause pauses the selected player
+func (c *Client) Pause() error {
+	return c.playerCommand("PUT", pausePath, url.Values{})
+}
+
+// Resume carries on playing whatever the selected player was paused on
+func (c *Client) Resume() error {
+	return c.playerCommand("PUT", resumePath, url.Values{})
+}
+
+// Next skips to the next track
+func (c *Client) Next() error {
+	return c.playerCommand("POST", nextPath, url.Values{})
+}
+
+// Previous goes back to the previous track
+func (c *Client) Previous() error {
+	return c.playerCommand("POST", previousPath, url.Values{})
+}
+
+// Seek moves to the position in the track playing
+func (c *Client) Seek(positionMS int) error {
+	return c.playerCommand("PUT", seekPath, url.Values{"position_ms": []string{strconv.Itoa(positionMS)}})
+}
+
+// Queue adds the track to the end of the selected player's queue
+func (c *Client) Queue(trackURI string) error {
+	return c.playerCommand("POST", queuePath, url.Values{"uri": []string{trackURI}})
+}
+
+// QueueAlbum adds each of the album's tracks to the queue, in order. Spotify
+// can't take a track back off the queue, so when one fails the tracks before
+// it stay queued, queued says how many of the album's tracks made it.
+func (c *Client) QueueAlbum(albumURI string) (queued, total int, err error) {
+	tracks, err := c.AlbumTracks(albumURI)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, track := range tracks {
+		err = c.Queue(track.URI)
+		if err != nil {
+			return queued, len(tracks), err
+		}
+		queued++
+	}
+	return queued, len(tracks), nil
+}
@@ -0,0 +1,153 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Config key, the session playlist as "name|id" so it isn't looked up
+	// every time
+	configPlaylist = "spotify_session_playlist"
+
+	saveAlbumPath      = "/me/albums?%v"
+	playlistsPath      = "/me/playlists?limit=50"
+	createPlaylistPath = "/me/playlists"
+	playlistTracksPath = "/playlists/%v/tracks"
+	maxPlaylistTracks  = 100
+)
+
+type Playlist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+type playlistsResponse struct {
+	Items []Playlist `json:"items"`
+	Next  string     `json:"next"`
+}
+
+type createPlaylistRequest struct {
+	Name        string `json:"name"`
+	Public      bool   `json:"public"`
+	Description string `json:"description"`
+}
+
+type addTracksRequest struct {
+	URIs []string `json:"uris"`
+}
+
+// SaveAlbum adds the album to the account's library
+func (c *Client) SaveAlbum(albumURI string) error {
+	qs := url.Values{"ids": []string{ID(albumURI)}}
+	res, body, err := c.apiRequest("PUT", fmt.Sprintf(saveAlbumPath, qs.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return newAPIError(res.StatusCode, body)
+	}
+	return nil
+}
+
+// Playlist returns the account's private playlist with the name, creating it
+// if there isn't one
+func (c *Client) Playlist(name, description string) (Playlist, error) {
+	if stored := strings.SplitN(c.Store.Get(configPlaylist), "|", 2); len(stored) == 2 && stored[0] == name {
+		return Playlist{ID: stored[1], Name: name}, nil
+	}
+
+	playlist, found, err := c.findPlaylist(name)
+	if err != nil {
+		return Playlist{}, err
+	}
+	if !found {
+		playlist, err = c.createPlaylist(name, description)
+		if err != nil {
+			return Playlist{}, err
+		}
+	}
+
+	c.Store.Set(configPlaylist, playlist.Name+"|"+playlist.ID)
+	return playlist, nil
+}
+
+func (c *Client) findPlaylist(name string) (Playlist, bool, error) {
+	path := playlistsPath
+	for path != "" {
+		res, body, err := c.apiRequest("GET", path, nil)
+		if err != nil {
+			return Playlist{}, false, err
+		}
+		if res.StatusCode != http.StatusOK {
+			return Playlist{}, false, newAPIError(res.StatusCode, body)
+		}
+
+		var data playlistsResponse
+		err = json.Unmarshal(body, &data)
+		if err != nil {
+			return Playlist{}, false, err
+		}
+		for _, playlist := range data.Items {
+			if playlist.Name == name {
+				return playlist, true, nil
+			}
+		}
+
+		// next is a full URL, we only want the path
+		path = strings.TrimPrefix(data.Next, c.APIURL)
+	}
+	return Playlist{}, false, nil
+}
+
+func (c *Client) createPlaylist(name, description string) (Playlist, error) {
+	b, err := json.Marshal(createPlaylistRequest{Name: name, Description: description})
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	res, body, err := c.apiRequest("POST", createPlaylistPath, bytes.NewReader(b))
+	if err != nil {
+		return Playlist{}, err
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return Playlist{}, newAPIError(res.StatusCode, body)
+	}
+
+	var playlist Playlist
+	err = json.Unmarshal(body, &playlist)
+	return playlist, err
+}
+
+// AddToPlaylist appends the tracks to the playlist
+func (c *Client) AddToPlaylist(playlistID string, trackURIs []string) error {
+	for len(trackURIs) > 0 {
+		n := len(trackURIs)
+		if n > maxPlaylistTracks {
+			n = maxPlaylistTracks
+		}
+
+		b, err := json.Marshal(addTracksRequest{URIs: trackURIs[:n]})
+		if err != nil {
+			return err
+		}
+		res, body, err := c.apiRequest("POST", fmt.Sprintf(playlistTracksPath, playlistID), bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+			if res.StatusCode == http.StatusNotFound {
+				// deleted since we stored it, look it up again next time
+				c.Store.Set(configPlaylist, "")
+			}
+			return newAPIError(res.StatusCode, body)
+		}
+		trackURIs = trackURIs[n:]
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package spotify
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// how much of the score the album name is worth when the artist is also
+	// known, the rest is the artist
+	albumWeight = 0.6
+
+	compilationPenalty = 0.7
+	singlePenalty      = 0.8
+)
+
+// keywordPenalties mark albums that are rarely the record on the turntable,
+// they don't apply when the query asks for the keyword too
+var keywordPenalties = []struct {
+	keyword string
+	penalty float64
+}{
+	{"karaoke", 0.3},
+	{"tribute", 0.3},
+	{"in the style of", 0.3},
+	{"made famous by", 0.3},
+	{"originally performed", 0.3},
+	{"lullaby", 0.4},
+	{"lullabies", 0.4},
+	{"renditions", 0.4},
+	{"cover versions", 0.4},
+	{"instrumental", 0.6},
+	{"live", 0.8},
+}
+
+// Match is a search result with how well it resembles the query, from 0 to 1
+type Match struct {
+	Album Album
+	Score float64
+}
+
+// rankAlbums scores each album against the query and sorts them best first,
+// dropping duplicates. Ties go to the earliest release, reissues usually come
+// later than the original.
+func rankAlbums(q Query, albums []Album) []Match {
+	matches := []Match{}
+	seen := map[string]bool{}
+	for _, album := range albums {
+		if album.URI == "" || seen[album.URI] {
+			continue
+		}
+		seen[album.URI] = true
+		matches = append(matches, Match{Album: album, Score: scoreAlbum(q, album)})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Album.ReleaseDate < matches[j].Album.ReleaseDate
+	})
+	return matches
+}
+
+func scoreAlbum(q Query, album Album) float64 {
+	artists := strings.Join(album.ArtistNames(), " ")
+
+	var score float64
+	if q.Artist != "" && q.Album != "" {
+		artistScore := 0.0
+		for _, name := range album.ArtistNames() {
+			if s := similarity(q.Artist, name); s > artistScore {
+				artistScore = s
+			}
+		}
+		score = albumWeight*similarity(q.Album, album.Name) + (1-albumWeight)*artistScore
+	} else {
+		// free text could be in either order
+		score = similarity(q.String(), album.Name+" "+artists)
+		if s := similarity(q.String(), artists+" "+album.Name); s > score {
+			score = s
+		}
+	}
+
+	switch album.AlbumType {
+	case "compilation":
+		score *= compilationPenalty
+	case "single":
+		score *= singlePenalty
+	}
+
+	wanted := " " + normalize(q.String()) + " "
+	found := " " + normalize(album.Name+" "+artists) + " "
+	for _, p := range keywordPenalties {
+		keyword := " " + p.keyword + " "
+		if strings.Contains(found, keyword) && !strings.Contains(wanted, keyword) {
+			score *= p.penalty
+		}
+	}
+
+	return score
+}
+
+// similarity is the Sørensen–Dice coefficient of the character bigrams of the
+// normalised strings, so small differences in spelling or word order still
+// score well
+func similarity(a, b string) float64 {
+	x, y := bigrams(normalize(a)), bigrams(normalize(b))
+	if len(x) == 0 || len(y) == 0 {
+		return 0
+	}
+
+	counts := map[string]int{}
+	for _, g := range x {
+		counts[g]++
+	}
+	shared := 0
+	for _, g := range y {
+		if counts[g] > 0 {
+			counts[g]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(x)+len(y))
+}
+
+// bigrams returns the pairs of letters within each word
+func bigrams(s string) []string {
+	grams := []string{}
+	for _, word := range strings.Fields(s) {
+		r := []rune(word)
+		if len(r) == 1 {
+			grams = append(grams, word)
+		}
+		for i := 0; i < len(r)-1; i++ {
+			grams = append(grams, string(r[i:i+2]))
+		}
+	}
+	return grams
+}
+
+// normalize lower cases the text and turns punctuation into spaces
+func normalize(s string) string {
+	s = strings.Replace(s, "&", " and ", -1)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return unicode.ToLower(r)
+		}
+		if r == '\'' || r == '’' {
+			return -1
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
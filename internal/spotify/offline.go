@@ -0,0 +1,52 @@
+package spotify
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// offlineToken stands in for the access token when replaying, the recorded
+// responses don't check it
+const offlineToken = "offline"
+
+// Offline returns a client for the same account that answers its requests
+// with h, such as a replayed bundle. Changes it makes, like a new player ID or
+// token, are kept in memory and never reach the account's store.
+func (c *Client) Offline(h *http.Client) *Client {
+	store := &offlineStore{store: c.Store, data: map[string]string{
+		configAccessToken: offlineToken,
+		configExpiry:      time.Now().AddDate(1, 0, 0).Format(defaultTimeFormat),
+	}}
+	return &Client{
+		AccountsURL:  c.AccountsURL,
+		APIURL:       c.APIURL,
+		HTTPClient:   h,
+		Store:        store,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Profile:      c.Profile,
+	}
+}
+
+// offlineStore reads through to the account's store and keeps writes to itself
+type offlineStore struct {
+	store TokenStore
+	data  map[string]string
+	sync.Mutex
+}
+
+func (s *offlineStore) Get(key string) string {
+	s.Lock()
+	defer s.Unlock()
+	if value, ok := s.data[key]; ok {
+		return value
+	}
+	return s.store.Get(key)
+}
+
+func (s *offlineStore) Set(key, value string) {
+	s.Lock()
+	defer s.Unlock()
+	s.data[key] = value
+}
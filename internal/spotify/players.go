@@ -0,0 +1,148 @@
+package spotify
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	// Config keys
+	configPlayerName      = "spotify_player_name"
+	configPlayerType      = "spotify_player_type"
+	configPlayerFallbacks = "spotify_player_fallbacks"
+	configPlayerStandIn   = "spotify_player_stand_in"
+)
+
+// SelectPlayer stores the player to use. Its name and type are kept as well
+// as the ID since Spotify Connect IDs change after firmware updates and
+// re-pairing.
+func (c *Client) SelectPlayer(device Device) {
+	c.Store.Set(configPlayer, device.ID)
+	c.Store.Set(configPlayerName, device.Name)
+	c.Store.Set(configPlayerType, device.Type)
+	c.Store.Set(configPlayerStandIn, "")
+}
+
+// PlayerName returns the selected player's name, or its ID if the name isn't
+// known
+func (c *Client) PlayerName() string {
+	if name := c.Store.Get(configPlayerName); name != "" {
+		return name
+	}
+	return c.Store.Get(configPlayer)
+}
+
+// playingName returns the name of the player albums are sent to, a fallback
+// standing in for the chosen player or the chosen player itself
+func (c *Client) playingName() string {
+	if name := c.Store.Get(configPlayerStandIn); name != "" {
+		return name
+	}
+	return c.PlayerName()
+}
+
+// Fallbacks returns the names of the players to try, in order, when the
+// selected one can't be found
+func (c *Client) Fallbacks() []string {
+	names := []string{}
+	for _, name := range strings.Split(c.Store.Get(configPlayerFallbacks), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (c *Client) SetFallbacks(names []string) {
+	c.Store.Set(configPlayerFallbacks, strings.Join(names, ","))
+}
+
+// ResolvePlayer finds the selected player among the account's devices and
+// updates its ID if it has changed. If it's gone the fallbacks are tried in
+// order, and if there's only one player it is chosen. When none of that works
+// the stored player is kept, it may just be asleep and missing from the list.
+func (c *Client) ResolvePlayer() error {
+	devices, err := c.GetPlayers()
+	if err != nil {
+		return err
+	}
+
+	available := []Device{}
+	for _, device := range devices {
+		if !device.Restricted {
+			available = append(available, device)
+		}
+	}
+
+	// the chosen player, by name first since its ID may have changed. While a
+	// fallback stands in the stored ID is the fallback's, so then the chosen
+	// player can only be found by name.
+	id := c.Store.Get(configPlayer)
+	name, deviceType := c.Store.Get(configPlayerName), c.Store.Get(configPlayerType)
+	standIn := c.Store.Get(configPlayerStandIn) != ""
+	if name != "" {
+		for _, device := range available {
+			if strings.EqualFold(device.Name, name) && (deviceType == "" || device.Type == deviceType) {
+				if device.ID != id {
+					log.Printf("player %v is now %v", name, device.ID)
+					if !standIn {
+						c.migrateVolume(id, name)
+					}
+					c.Store.Set(configPlayer, device.ID)
+				}
+				c.clearStandIn()
+				return nil
+			}
+		}
+	}
+	for _, device := range available {
+		if id != "" && device.ID == id && !standIn {
+			if name == "" {
+				// chosen before names were kept
+				c.SelectPlayer(device)
+			}
+			return nil
+		}
+	}
+
+	for _, fallback := range c.Fallbacks() {
+		for _, device := range available {
+			if strings.EqualFold(device.Name, fallback) {
+				log.Printf("player %v not found, falling back to %v", c.PlayerName(), device.Name)
+				c.useFallback(device)
+				return nil
+			}
+		}
+	}
+
+	if len(available) == 1 {
+		if id == "" && name == "" {
+			log.Println("only one player, choosing", available[0].Name)
+			c.SelectPlayer(available[0])
+		} else {
+			log.Printf("player %v not found, using the only one, %v", c.PlayerName(), available[0].Name)
+			c.useFallback(available[0])
+		}
+		return nil
+	}
+
+	if id == "" {
+		return fmt.Errorf("no spotify player selected")
+	}
+	return nil
+}
+
+// useFallback plays on the device for now without forgetting the chosen
+// player's name, so it's used again once it's back
+func (c *Client) useFallback(device Device) {
+	c.Store.Set(configPlayer, device.ID)
+	c.Store.Set(configPlayerStandIn, device.Name)
+}
+
+// clearStandIn goes back to the chosen player once it's found again
+func (c *Client) clearStandIn() {
+	if c.Store.Get(configPlayerStandIn) != "" {
+		c.Store.Set(configPlayerStandIn, "")
+	}
+}
@@ -0,0 +1,169 @@
+package spotify
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// Config keys
+	configShuffle      = "spotify_shuffle"
+	configRepeat       = "spotify_repeat"
+	configVolumeFormat = "spotify_volume_%v"
+
+	shufflePath = "/me/player/shuffle?%v"
+	repeatPath  = "/me/player/repeat?%v"
+	volumePath  = "/me/player/volume?%v"
+
+	// Policy values, leave doesn't change what the player is set to
+	PolicyLeave   = "leave"
+	ShuffleOff    = "off"
+	ShuffleOn     = "on"
+	RepeatOff     = "off"
+	RepeatContext = "context"
+	RepeatTrack   = "track"
+)
+
+// Policy is how the player is set up before an album starts. Records are
+// played in order, so by default shuffle and repeat are turned off.
+type Policy struct {
+	Shuffle string
+	Repeat  string
+	// Volume is a percentage for the player albums are sent to, -1 leaves it
+	// alone
+	Volume int
+}
+
+// Policy returns the playback policy for the player albums are sent to
+func (c *Client) Policy() Policy {
+	c.migrateVolume(c.Store.Get(configPlayer), c.playingName())
+
+	p := Policy{
+		Shuffle: c.Store.Get(configShuffle),
+		Repeat:  c.Store.Get(configRepeat),
+		Volume:  -1,
+	}
+	if p.Shuffle == "" {
+		p.Shuffle = ShuffleOff
+	}
+	if p.Repeat == "" {
+		p.Repeat = RepeatOff
+	}
+	if volume, err := strconv.Atoi(c.Store.Get(c.volumeKey())); err == nil {
+		p.Volume = volume
+	}
+	return p
+}
+
+// SetPolicy stores the playback policy, the volume is kept for the player
+// albums are sent to since speakers need different levels
+func (c *Client) SetPolicy(p Policy) error {
+	switch p.Shuffle {
+	case ShuffleOff, ShuffleOn, PolicyLeave:
+	default:
+		return fmt.Errorf("%v isn't a shuffle setting", p.Shuffle)
+	}
+	switch p.Repeat {
+	case RepeatOff, RepeatContext, RepeatTrack, PolicyLeave:
+	default:
+		return fmt.Errorf("%v isn't a repeat setting", p.Repeat)
+	}
+	if p.Volume > 100 {
+		return fmt.Errorf("the volume has to be between 0 and 100")
+	}
+
+	c.Store.Set(configShuffle, p.Shuffle)
+	c.Store.Set(configRepeat, p.Repeat)
+	if c.HasPlayer() {
+		volume := ""
+		if p.Volume >= 0 {
+			volume = strconv.Itoa(p.Volume)
+		}
+		c.Store.Set(c.volumeKey(), volume)
+	}
+	return nil
+}
+
+// volumeKey is by the name of the player albums are sent to, which unlike its
+// ID doesn't change. While a fallback stands in it gets its own volume.
+func (c *Client) volumeKey() string {
+	return fmt.Sprintf(configVolumeFormat, c.playingName())
+}
+
+// migrateVolume moves a volume kept by the player's ID, as it used to be, to
+// its name
+func (c *Client) migrateVolume(id, name string) {
+	if id == "" || name == "" || id == name {
+		return
+	}
+	old, key := fmt.Sprintf(configVolumeFormat, id), fmt.Sprintf(configVolumeFormat, name)
+	volume := c.Store.Get(old)
+	if volume == "" {
+		return
+	}
+	if c.Store.Get(key) == "" {
+		c.Store.Set(key, volume)
+	}
+	c.Store.Set(old, "")
+}
+
+// applyPolicy sets up the player before an album starts. Failures are only
+// logged, it's better to play the album than nothing.
+func (c *Client) applyPolicy() {
+	p := c.Policy()
+
+	if p.Shuffle != PolicyLeave {
+		err := c.SetShuffle(p.Shuffle == ShuffleOn)
+		if err != nil {
+			log.Println("unable to set shuffle:", err)
+		}
+	}
+	if p.Repeat != PolicyLeave {
+		err := c.SetRepeat(p.Repeat)
+		if err != nil {
+			log.Println("unable to set repeat:", err)
+		}
+	}
+	if p.Volume >= 0 {
+		err := c.SetVolume(p.Volume)
+		if err != nil {
+			log.Println("unable to set volume:", err)
+		}
+	}
+}
+
+// SetShuffle turns shuffle on or off for the selected player
+func (c *Client) SetShuffle(shuffle bool) error {
+	return c.playerCommand("PUT", shufflePath, url.Values{"state": []string{strconv.FormatBool(shuffle)}})
+}
+
+// SetRepeat sets the selected player to repeat the track, the album (context)
+// or nothing (off)
+func (c *Client) SetRepeat(mode string) error {
+	return c.playerCommand("PUT", repeatPath, url.Values{"state": []string{mode}})
+}
+
+// SetVolume sets the selected player's volume as a percentage
+func (c *Client) SetVolume(percent int) error {
+	return c.playerCommand("PUT", volumePath, url.Values{"volume_percent": []string{strconv.Itoa(percent)}})
+}
+
+// playerCommand sends a command or setting to the selected player
+func (c *Client) playerCommand(method, path string, qs url.Values) error {
+	if !c.HasPlayer() {
+		return fmt.Errorf("no spotify player selected")
+	}
+	qs.Set("device_id", c.Store.Get(configPlayer))
+
+	res, body, err := c.apiRequest(method, fmt.Sprintf(path, qs.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code response: %v %v", res.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package spotify
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultProfile uses the original un-namespaced config keys, so existing
+	// setups keep their account
+	DefaultProfile = "default"
+
+	// Config keys
+	configProfiles = "spotify_profiles"
+	configProfile  = "spotify_profile"
+
+	profileKeyFormat = "profile_%v_%v"
+)
+
+// profileKeys belong to an account, anything else, like the redirect URI, is
+// shared by every profile
+var profileKeys = []string{
+	configExpiry, configAccessToken, configRefreshToken, configScope,
+	configPlayer, configPlayerName, configPlayerType, configPlayerFallbacks, configPlayerStandIn,
+	configPlaylist,
+}
+
+var profileName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// profileStore namespaces a profile's account keys within the shared store
+type profileStore struct {
+	store TokenStore
+	name  string
+}
+
+func (s profileStore) key(key string) string {
+	if s.name == DefaultProfile {
+		return key
+	}
+	for _, k := range profileKeys {
+		if k == key {
+			return fmt.Sprintf(profileKeyFormat, s.name, key)
+		}
+	}
+	return key
+}
+
+func (s profileStore) Get(key string) string {
+	return s.store.Get(s.key(key))
+}
+
+func (s profileStore) Set(key, value string) {
+	s.store.Set(s.key(key), value)
+}
+
+// Profiles are the household's Spotify accounts, each with its own tokens and
+// player. One of them is current and used unless a scan asks for another.
+type Profiles struct {
+	Store TokenStore
+
+	clients map[string]*Client
+	sync.Mutex
+}
+
+// NewProfiles returns the profiles kept in the store
+func NewProfiles(store TokenStore) *Profiles {
+	return &Profiles{
+		Store:   store,
+		clients: make(map[string]*Client),
+	}
+}
+
+// Names returns every profile, the default first
+func (p *Profiles) Names() []string {
+	p.Lock()
+	defer p.Unlock()
+	return p.names()
+}
+
+func (p *Profiles) names() []string {
+	names := []string{}
+	for _, name := range strings.Split(p.Store.Get(configProfiles), ",") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+func (p *Profiles) exists(name string) bool {
+	for _, n := range p.names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add creates a new profile, names are lower case letters, numbers and dashes
+func (p *Profiles) Add(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("profile names can only use letters, numbers and dashes")
+	}
+	if p.exists(name) {
+		return fmt.Errorf("there's already a profile called %v", name)
+	}
+
+	names := append(p.names()[1:], name)
+	p.Store.Set(configProfiles, strings.Join(names, ","))
+	return nil
+}
+
+// Remove logs the profile out and deletes it, the default can't be removed
+func (p *Profiles) Remove(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if name == DefaultProfile {
+		return fmt.Errorf("the default profile can't be removed")
+	}
+	if !p.exists(name) {
+		return fmt.Errorf("there's no profile called %v", name)
+	}
+
+	client := p.client(name)
+	client.Logout()
+	client.SelectPlayer(Device{})
+	client.SetFallbacks(nil)
+	delete(p.clients, name)
+
+	names := []string{}
+	for _, n := range p.names()[1:] {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	p.Store.Set(configProfiles, strings.Join(names, ","))
+
+	if p.Store.Get(configProfile) == name {
+		p.Store.Set(configProfile, "")
+	}
+	return nil
+}
+
+// Current returns the name of the profile scans use by default
+func (p *Profiles) Current() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.current()
+}
+
+func (p *Profiles) current() string {
+	name := p.Store.Get(configProfile)
+	if name == "" || !p.exists(name) {
+		return DefaultProfile
+	}
+	return name
+}
+
+// Switch makes the profile current
+func (p *Profiles) Switch(name string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.exists(name) {
+		return fmt.Errorf("there's no profile called %v", name)
+	}
+	p.Store.Set(configProfile, name)
+	return nil
+}
+
+// Client returns the client for the profile, or the current profile when name
+// is empty
+func (p *Profiles) Client(name string) (*Client, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if name == "" {
+		name = p.current()
+	}
+	if !p.exists(name) {
+		return nil, fmt.Errorf("there's no profile called %v", name)
+	}
+	return p.client(name), nil
+}
+
+// CurrentClient returns the client for the current profile
+func (p *Profiles) CurrentClient() *Client {
+	p.Lock()
+	defer p.Unlock()
+	return p.client(p.current())
+}
+
+// ForState returns the client whose login the state belongs to, so the shared
+// callback can finish the login for the right profile
+func (p *Profiles) ForState(state string) (*Client, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, name := range p.names() {
+		client := p.client(name)
+		if client.hasAttempt(state) {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+// client returns the profile's client, keeping them so logins in progress and
+// token refreshes are shared
+func (p *Profiles) client(name string) *Client {
+	client, ok := p.clients[name]
+	if !ok {
+		client = NewClient(profileStore{store: p.Store, name: name})
+		client.Profile = name
+		p.clients[name] = client
+	}
+	return client
+}
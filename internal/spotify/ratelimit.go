@@ -0,0 +1,134 @@
+package spotify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// how many times a request is retried after a 429 or 5xx
+	maxRetries = 3
+
+	// longer Retry-After waits are handed back to the caller as a
+	// RateLimitError rather than holding up the request
+	maxRetryWait = 10 * time.Second
+
+	// the first wait after a 5xx, it doubles each retry
+	serverErrorBackoff = time.Second
+
+	// used when a 429 doesn't say how long to wait
+	defaultRetryAfter = time.Second
+)
+
+// RateLimitError means Spotify asked us to wait longer than we're willing to
+// within one request
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("spotify is rate limiting us, retry in %v", e.RetryAfter)
+}
+
+// IsRateLimited reports whether the error is a RateLimitError and how long to
+// wait before trying again
+func IsRateLimited(err error) (time.Duration, bool) {
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return rateErr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// retryTransport retries requests Spotify answers with 429, after the wait it
+// asks for, or with a 5xx, backing off each time. A POST that failed with a
+// 5xx may still have been done, like a track added to the queue, so those are
+// only retried after a 429.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+// retryClient returns an HTTP client that retries rate limited and failed
+// requests
+func (c *Client) retryClient() *http.Client {
+	return &http.Client{
+		Transport: &retryTransport{base: c.HTTPClient.Transport},
+		Timeout:   c.HTTPClient.Timeout,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	retry := req
+	for attempt := 0; ; attempt++ {
+		res, err := base.RoundTrip(retry)
+		if err != nil {
+			return nil, err
+		}
+
+		wait, ok := retryWait(req.Method, res, attempt)
+		if !ok {
+			return res, nil
+		}
+		if wait > maxRetryWait || (res.StatusCode == http.StatusTooManyRequests && attempt == maxRetries) {
+			res.Body.Close()
+			log.Printf("spotify is rate limiting us for %v", wait)
+			return nil, &RateLimitError{RetryAfter: wait}
+		}
+		if attempt == maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return res, nil
+		}
+		res.Body.Close()
+		log.Printf("spotify answered %v, retrying in %v", res.StatusCode, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		retry = req.Clone(req.Context())
+		if req.GetBody != nil {
+			retry.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryWait returns how long to wait before retrying the response, ok is false
+// when it shouldn't be retried
+func retryWait(method string, res *http.Response, attempt int) (wait time.Duration, ok bool) {
+	switch res.StatusCode {
+	case http.StatusTooManyRequests:
+		seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+		if err != nil || seconds < 0 {
+			return defaultRetryAfter, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if !idempotent(method) {
+			return 0, false
+		}
+		return serverErrorBackoff << uint(attempt), true
+	}
+	return 0, false
+}
+
+// idempotent reports whether doing the request twice is the same as once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -4,42 +4,113 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
-
-	"github.com/jccroft1/autorecord/internal/config"
+	"sync"
 )
 
 const (
 	// Environment names
 	envClientID     = "SPOTIFY_CLIENTID"
 	envClientSecret = "SPOTIFY_CLIENTSECRET"
+	envRedirectURL  = "SPOTIFY_REDIRECT_URI"
 
 	// Config key
 	configExpiry       = "spotify_expiry"
 	configAccessToken  = "spotify_access_token"
 	configRefreshToken = "spotify_refresh_token"
+	configScope        = "spotify_scope"
 	configPlayer       = "spotify_player"
+	configRedirectURL  = "spotify_redirect_uri"
 
-	// App
-	callbackURL = "http://autorecord.local/spotify/callback"
+	// App, Spotify only allows plain http redirects to loopback addresses so
+	// by default the login finishes by pasting the address back into the app
+	DefaultRedirectURL = "http://127.0.0.1:8888/callback"
 
 	// Spotify API
-	authURL        = "https://accounts.spotify.com/authorize?%v"
-	tokenURL       = "https://accounts.spotify.com/api/token"
-	searchURL      = "https://api.spotify.com/v1/search?%v"
-	listDevicesURL = "https://api.spotify.com/v1/me/player/devices"
-	playerURL      = "https://api.spotify.com/v1/me/player/play?device_id=%v"
+	DefaultAccountsURL = "https://accounts.spotify.com"
+	DefaultAPIURL      = "https://api.spotify.com/v1"
+
+	authPath        = "/authorize?%v"
+	tokenPath       = "/api/token"
+	searchPath      = "/search?%v"
+	listDevicesPath = "/me/player/devices"
+	playerPath      = "/me/player/play?device_id=%v"
+	playbackPath    = "/me/player"
+	albumTracksPath = "/albums/%v/tracks?limit=50"
 
 	defaultTimeFormat = "2006-01-02 15:04:05"
+
+	// enough results that the right album is usually among them
+	searchLimit = 20
+
+	// below this the field filtered results are probably wrong, so free text
+	// results are added too
+	filteredScore = 0.5
 )
 
+var albumURIPattern = regexp.MustCompile(`^spotify:album:[0-9A-Za-z]+$`)
+
+// TokenStore keeps the tokens and player choice, *config.Config is one
+type TokenStore interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// Client talks to Spotify for one account
+type Client struct {
+	AccountsURL  string
+	APIURL       string
+	HTTPClient   *http.Client
+	Store        TokenStore
+	ClientID     string
+	ClientSecret string
+	Profile      string
+
+	attempts    map[string]authAttempt
+	attemptLock sync.Mutex
+	refreshLock sync.Mutex
+	refreshing  *refreshCall
+
+	// parent refreshes the tokens for clients made by WithHTTPClient
+	parent *Client
+}
+
+// NewClient returns a client for the real Spotify API with the app credentials
+// from the environment
+func NewClient(store TokenStore) *Client {
+	return &Client{
+		AccountsURL:  DefaultAccountsURL,
+		APIURL:       DefaultAPIURL,
+		HTTPClient:   http.DefaultClient,
+		Store:        store,
+		ClientID:     os.Getenv(envClientID),
+		ClientSecret: os.Getenv(envClientSecret),
+	}
+}
+
+// WithHTTPClient returns a client for the same account that sends its requests
+// with h, such as a scan's bundle. Token refreshes are still shared with c.
+func (c *Client) WithHTTPClient(h *http.Client) *Client {
+	return &Client{
+		AccountsURL:  c.AccountsURL,
+		APIURL:       c.APIURL,
+		HTTPClient:   h,
+		Store:        c.Store,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Profile:      c.Profile,
+		parent:       c,
+	}
+}
+
 type SearchResponse struct {
 	Albums AlbumList `json:"albums"`
 	Tracks TrackList `json:"tracks"`
@@ -54,16 +125,74 @@ type TrackList struct {
 }
 
 type Album struct {
-	URI string `json:"uri"`
+	URI         string   `json:"uri"`
+	Name        string   `json:"name"`
+	AlbumType   string   `json:"album_type"`
+	ReleaseDate string   `json:"release_date"`
+	Artists     []Artist `json:"artists"`
+	Images      []Image  `json:"images"`
+}
+
+type Image struct {
+	URL    string `json:"url"`
+	Height int    `json:"height"`
+	Width  int    `json:"width"`
+}
+
+type Artist struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
 }
 
 type Track struct {
-	URI   string `json:"uri"`
-	Album Album  `json:"album"`
+	URI         string   `json:"uri"`
+	Name        string   `json:"name"`
+	TrackNumber int      `json:"track_number"`
+	DiscNumber  int      `json:"disc_number"`
+	DurationMS  int      `json:"duration_ms"`
+	Artists     []Artist `json:"artists"`
+	Album       Album    `json:"album"`
 }
 
 type PlayRequest struct {
-	URI string `json:"context_uri"`
+	URI        string  `json:"context_uri"`
+	Offset     *Offset `json:"offset,omitempty"`
+	PositionMS int     `json:"position_ms,omitempty"`
+}
+
+// Offset is where in the album playback starts, either the position of a
+// track counting from 0 or the track's URI
+type Offset struct {
+	Position *int   `json:"position,omitempty"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// PositionOffset starts playback at the track position, counting from 0
+func PositionOffset(position int) *Offset {
+	return &Offset{Position: &position}
+}
+
+// PlaybackState is what the account is playing right now
+type PlaybackState struct {
+	Device     Device   `json:"device"`
+	IsPlaying  bool     `json:"is_playing"`
+	ProgressMS int      `json:"progress_ms"`
+	Context    *Context `json:"context"`
+	Item       *Track   `json:"item"`
+}
+
+// Context is the album or playlist playback is from
+type Context struct {
+	URI  string `json:"uri"`
+	Type string `json:"type"`
+}
+
+// ContextURI returns the URI of the album playing, or "" when nothing is
+func (s PlaybackState) ContextURI() string {
+	if s.Context == nil {
+		return ""
+	}
+	return s.Context.URI
 }
 
 type DevicesResponse struct {
@@ -75,58 +204,36 @@ type Device struct {
 	Restricted bool   `json:"is_restricted"`
 	Name       string `json:"name"`
 	Type       string `json:"type"`
+	Volume     int    `json:"volume_percent"`
 }
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-	if os.Getenv(envClientID) == "" || os.Getenv(envClientSecret) == "" {
-		panic("unable to load spotify client credentials")
-	}
+// HasCredentials reports whether the app's Spotify client ID is set up, the
+// secret is optional
+func (c *Client) HasCredentials() bool {
+	return c.ClientID != ""
 }
 
-func IsAuthed() bool {
-	if config.Get(configAccessToken) == "" {
+func (c *Client) IsAuthed() bool {
+	if c.Store.Get(configAccessToken) == "" {
 		return false
 	}
 	return true
 }
 
-func HasPlayer() bool {
-	if config.Get(configPlayer) == "" {
+func (c *Client) HasPlayer() bool {
+	if c.Store.Get(configPlayer) == "" && c.Store.Get(configPlayerName) == "" {
 		return false
 	}
 	return true
 }
 
-func ProcessPlayer(player string) {
-	config.Set(configPlayer, player)
-}
-
-func GetPlayers() ([]Device, error) {
-	err := checkToken()
-	if err != nil {
-		return []Device{}, err
-	}
-
-	req, err := http.NewRequest("GET", listDevicesURL, strings.NewReader(""))
-	if err != nil {
-		return []Device{}, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", config.Get(configAccessToken)))
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return []Device{}, err
-	}
-
-	body, err := ioutil.ReadAll(res.Body)
+func (c *Client) GetPlayers() ([]Device, error) {
+	res, body, err := c.apiRequest("GET", listDevicesPath, nil)
 	if err != nil {
 		return []Device{}, err
 	}
 
 	if res.StatusCode != http.StatusOK {
-		// refresh token if 401?
 		return []Device{}, fmt.Errorf("bad status code response: %v", string(body))
 	}
 
@@ -139,235 +246,247 @@ func GetPlayers() ([]Device, error) {
 	return data.Devices, nil
 }
 
-func SearchAlbum(text string) (string, error) {
-	err := checkToken()
-	if err != nil {
-		return "", err
+// Query is what we're searching Spotify for. Field filters are used when both
+// the artist and album are known, otherwise Text is searched as is.
+type Query struct {
+	Artist string
+	Album  string
+	Text   string
+}
+
+func (q Query) String() string {
+	if q.Text != "" {
+		return q.Text
 	}
+	return strings.TrimSpace(q.Album + " " + q.Artist)
+}
 
-	qs := url.Values{}
-	qs.Set("q", text)
-	qs.Set("type", "album,track")
-	// qs["type"] = []string{"artist", "album"}
-	qs.Set("limit", "5") // TODO: Reduce to 1 eventually?
+// ImageURL returns the album art URL, Spotify lists the largest image first
+func (a Album) ImageURL() string {
+	if len(a.Images) == 0 {
+		return ""
+	}
+	return a.Images[0].URL
+}
+
+// ArtistNames returns the names of the album's artists
+func (a Album) ArtistNames() []string {
+	names := []string{}
+	for _, artist := range a.Artists {
+		names = append(names, artist.Name)
+	}
+	return names
+}
 
-	url := fmt.Sprintf(searchURL, qs.Encode())
-	req, err := http.NewRequest("GET", url, strings.NewReader(""))
+// SearchAlbum returns the album that best matches the query
+func (c *Client) SearchAlbum(q Query) (Album, error) {
+	matches, err := c.SearchAlbums(q)
 	if err != nil {
-		return "", err
+		return Album{}, err
 	}
+	return matches[0].Album, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", config.Get(configAccessToken)))
-	res, err := http.DefaultClient.Do(req)
+// SearchAlbums returns the albums found for the query, best first, scored by
+// how much they resemble it so callers can decide whether to trust the best.
+// Albums of the tracks found count too, the track may be better known.
+func (c *Client) SearchAlbums(q Query) ([]Match, error) {
+	matches := []Match{}
+	if q.Artist != "" && q.Album != "" {
+		filtered := fmt.Sprintf("album:%v artist:%v", quoteField(q.Album), quoteField(q.Artist))
+		data, err := c.search(filtered, "album")
+		if err != nil {
+			return nil, err
+		}
+		matches = rankAlbums(q, data.Albums.Items)
+		if len(matches) > 0 && matches[0].Score >= filteredScore {
+			return matches, nil
+		}
+		log.Println("no good results with field filters, trying free text:", filtered)
+	}
+
+	text := q.String()
+	data, err := c.search(text, "album,track")
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	albums := data.Albums.Items
+	for _, track := range data.Tracks.Items {
+		albums = append(albums, track.Album)
+	}
+	for _, m := range matches {
+		albums = append(albums, m.Album)
+	}
+
+	// the split didn't find the album so it may be wrong, "Stand By Me" isn't
+	// "Stand" by "Me", rank against the whole text instead
+	if q.Text != "" {
+		q = Query{Text: q.Text}
 	}
+	matches = rankAlbums(q, albums)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no results found for %v", text)
+	}
+	return matches, nil
+}
+
+// quoteField quotes a field filter value so multi word names stay together
+func quoteField(value string) string {
+	return `"` + strings.Replace(value, `"`, "", -1) + `"`
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+func (c *Client) search(text, types string) (SearchResponse, error) {
+	qs := url.Values{}
+	qs.Set("q", text)
+	qs.Set("type", types)
+	qs.Set("limit", strconv.Itoa(searchLimit))
+
+	res, body, err := c.apiRequest("GET", fmt.Sprintf(searchPath, qs.Encode()), nil)
 	if err != nil {
-		return "", err
+		return SearchResponse{}, err
 	}
 
 	if res.StatusCode != http.StatusOK {
-		// refresh token if 401?
-		return "", fmt.Errorf("bad status code response: %v", string(body))
+		return SearchResponse{}, fmt.Errorf("bad status code response: %v", string(body))
 	}
 
 	var data SearchResponse
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return "", err
-	}
-
-	if len(data.Albums.Items) > 0 {
-		return data.Albums.Items[0].URI, nil
-		// TODO: Check list of tracks
+		return SearchResponse{}, err
 	}
 
-	if len(data.Tracks.Items) > 0 {
-		return data.Tracks.Items[0].Album.URI, nil
-	}
+	return data, nil
+}
 
-	return "", fmt.Errorf("no results found for %v", text)
+func (c *Client) PlayItem(uri string) error {
+	return c.Play(PlayRequest{URI: uri})
 }
 
-func PlayItem(uri string) error {
-	err := checkToken()
-	if err != nil {
+// Play starts the request on the player, use an offset to start part way
+// through the album. A player that has gone to sleep is woken up first, and
+// Play only returns once the album is playing.
+func (c *Client) Play(requestBody PlayRequest) error {
+	err := c.ResolvePlayer()
+	if err != nil && !c.HasPlayer() {
 		return err
 	}
-	if !HasPlayer() {
-		return fmt.Errorf("no spotify player selected")
+	if err != nil {
+		log.Println("unable to check the player, using the one chosen:", err)
 	}
+	c.applyPolicy()
 
-	requestBody := PlayRequest{
-		URI: uri,
-	}
-	url := fmt.Sprintf(playerURL, config.Get(configPlayer))
 	b, err := json.Marshal(requestBody)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(b))
+
+	err = c.startPlayback(b)
+	if isNotFound(err) {
+		log.Println("spotify player inactive, waking it up:", err)
+		err = c.TransferPlayback(c.Store.Get(configPlayer))
+		if err != nil {
+			return err
+		}
+		c.applyPolicy()
+		err = c.startPlayback(b)
+	}
+	if isNotFound(err) {
+		log.Println("spotify player still inactive:", err)
+		return ErrPlayerUnavailable
+	}
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", config.Get(configAccessToken)))
-	res, err := http.DefaultClient.Do(req)
+	return c.waitForPlayback(requestBody.URI)
+}
+
+func (c *Client) startPlayback(b []byte) error {
+	res, body, err := c.apiRequest("PUT", fmt.Sprintf(playerPath, c.Store.Get(configPlayer)), bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
 	if res.StatusCode != http.StatusNoContent {
-		body, _ := ioutil.ReadAll(res.Body)
-		return fmt.Errorf("bad status code response: %v %v", res.StatusCode, string(body))
+		return newAPIError(res.StatusCode, body)
 	}
 
 	return nil
 }
 
-// Auth stuff
-
-var (
-	state          = ""
-	requiredScopes = []string{
-		"user-modify-playback-state", // Start or resume playback
-		"user-read-playback-state",   // Get Players
-		"user-read-private",          // Search for an item
+// Playback returns what the account is playing, ok is false when nothing is
+// playing on any device
+func (c *Client) Playback() (state PlaybackState, ok bool, err error) {
+	res, body, err := c.apiRequest("GET", playbackPath, nil)
+	if err != nil {
+		return PlaybackState{}, false, err
 	}
-)
-
-type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	// TokenType string `json:"token_type"`
-	// Scope string `json:"scope"`
-	Expiry       int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
-}
-
-type RefreshResponse struct {
-	AccessToken string `json:"access_token"`
-	// TokenType string `json:"token_type"`
-	// Scope string `json:"scope"`
-	Expiry int `json:"expires_in"`
-}
-
-func ProcessCallback(qs url.Values) error {
-	if qs.Get("state") != state {
-		return fmt.Errorf("state validation failed")
+	if res.StatusCode == http.StatusNoContent {
+		return PlaybackState{}, false, nil
 	}
-
-	// extract query string stuff
-	code := qs.Get("code")
-	if code == "" {
-		return fmt.Errorf("Failed to get code: %v", qs.Get("error"))
+	if res.StatusCode != http.StatusOK {
+		return PlaybackState{}, false, fmt.Errorf("bad status code response: %v %v", res.StatusCode, string(body))
 	}
 
-	requestTime := time.Now()
-	tokenData, err := getAuthToken(code)
+	err = json.Unmarshal(body, &state)
 	if err != nil {
-		return err
-	}
-	if tokenData.AccessToken == "" || tokenData.RefreshToken == "" {
-		return fmt.Errorf("spotify tokens not returned")
+		return PlaybackState{}, false, err
 	}
-	config.Set(configExpiry, requestTime.Add(time.Duration(tokenData.Expiry)*time.Second).Format(defaultTimeFormat))
-	config.Set(configAccessToken, tokenData.AccessToken)
-	config.Set(configRefreshToken, tokenData.RefreshToken)
-
-	return nil
+	return state, true, nil
 }
 
-func GetAuthURL() (string, error) {
-	client_id := os.Getenv(envClientID)
-	if client_id == "" {
-		return "", fmt.Errorf("Failed to get Spotify client_id from environment")
+// AlbumTracks returns the album's tracks in order, only the first 50 which is
+// plenty for a record
+func (c *Client) AlbumTracks(albumURI string) ([]Track, error) {
+	res, body, err := c.apiRequest("GET", fmt.Sprintf(albumTracksPath, ID(albumURI)), nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code response: %v %v", res.StatusCode, string(body))
 	}
 
-	state = fmt.Sprint(rand.Int())
-
-	v := url.Values{
-		"response_type": []string{"code"},
-		"client_id":     []string{client_id},
-		"scope":         []string{strings.Join(requiredScopes, " ")},
-		"redirect_uri":  []string{callbackURL},
-		"state":         []string{state},
+	var data TrackList
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf(authURL, v.Encode()), nil
+	return data.Items, nil
 }
 
-func getAuthToken(code string) (TokenResponse, error) {
-	form := url.Values{}
-	form.Set("grant_type", "authorization_code")
-	form.Set("code", code)
-	form.Set("redirect_uri", callbackURL)
-
-	var response TokenResponse
-	err := getToken(form, &response)
-	return response, err
+// ID returns the ID part of a URI such as spotify:album:ID
+func ID(uri string) string {
+	return uri[strings.LastIndex(uri, ":")+1:]
 }
 
-func getRefreshToken(token string) (RefreshResponse, error) {
-	form := url.Values{}
-	form.Set("grant_type", "refresh_token")
-	form.Set("refresh_token", token)
-
-	var response RefreshResponse
-	err := getToken(form, &response)
-	return response, err
+// IsAlbumURI reports whether the text is a Spotify album URI, so it's safe to
+// put its ID in an API path
+func IsAlbumURI(uri string) bool {
+	return albumURIPattern.MatchString(uri)
 }
 
-func getToken(form url.Values, data interface{}) error {
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.SetBasicAuth(os.Getenv(envClientID), os.Getenv(envClientSecret))
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
+// apiRequest makes an authorised request to the Web API and reads the response
+func (c *Client) apiRequest(method, path string, body io.Reader) (*http.Response, []byte, error) {
+	if body == nil {
+		body = strings.NewReader("")
 	}
-
-	err = json.Unmarshal(body, data)
+	req, err := http.NewRequest(method, c.APIURL+path, body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
-}
-
-func checkToken() error {
-	expiryTime, err := time.Parse(defaultTimeFormat, config.Get(configExpiry))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.apiClient().Do(req)
 	if err != nil {
-		return fmt.Errorf("unable to check expiry: %V", err)
-	}
-
-	if expiryTime.After(time.Now()) {
-		// token has not yet expired, no need to refresh
-		return nil
+		return nil, nil, err
 	}
+	defer res.Body.Close()
 
-	log.Println("expiry token expired, refreshing")
-
-	requestTime := time.Now()
-	tokenData, err := getRefreshToken(config.Get(configRefreshToken))
+	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	if tokenData.AccessToken == "" {
-		return fmt.Errorf("spotify token not returned")
-	}
-	config.Set(configExpiry, requestTime.Add(time.Duration(tokenData.Expiry)*time.Second).Format(defaultTimeFormat))
-	config.Set(configAccessToken, tokenData.AccessToken)
 
-	return nil
+	return res, resBody, nil
 }
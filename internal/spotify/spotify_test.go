@@ -0,0 +1,212 @@
+package spotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testStore is an in memory TokenStore
+type testStore struct {
+	data map[string]string
+	sync.Mutex
+}
+
+func (s *testStore) Get(key string) string {
+	s.Lock()
+	defer s.Unlock()
+	return s.data[key]
+}
+
+func (s *testStore) Set(key, value string) {
+	s.Lock()
+	defer s.Unlock()
+	s.data[key] = value
+}
+
+// newTestClient returns a client for the test server, logged in with the
+// access token
+func newTestClient(srv *httptest.Server, token string, expiry time.Time) (*Client, *testStore) {
+	store := &testStore{data: map[string]string{
+		configAccessToken:  token,
+		configRefreshToken: "refresh",
+		configExpiry:       expiry.Format(defaultTimeFormat),
+	}}
+	return &Client{
+		AccountsURL:  srv.URL,
+		APIURL:       srv.URL + "/v1",
+		HTTPClient:   srv.Client(),
+		Store:        store,
+		ClientID:     "id",
+		ClientSecret: "secret",
+	}, store
+}
+
+func TestSearchAlbums(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/search" {
+			t.Errorf("unexpected request %v", req.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("authorization is %q", got)
+		}
+		if got := req.URL.Query().Get("q"); got != `album:"Parachutes" artist:"Coldplay"` {
+			t.Errorf("searched for %q", got)
+		}
+		w.Write([]byte(`{"albums":{"items":[
+			{"uri":"spotify:album:karaoke","name":"Parachutes (Karaoke Version)","album_type":"album","artists":[{"name":"Karaoke Stars"}]},
+			{"uri":"spotify:album:parachutes","name":"Parachutes","album_type":"album","artists":[{"name":"Coldplay"}]}
+		]}}`))
+	}))
+	defer srv.Close()
+
+	client, _ := newTestClient(srv, "access", time.Now().Add(time.Hour))
+	matches, err := client.SearchAlbums(Query{Artist: "Coldplay", Album: "Parachutes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches[0].Album.URI != "spotify:album:parachutes" {
+		t.Errorf("best match is %v", matches[0].Album.URI)
+	}
+}
+
+func TestRefreshOnce(t *testing.T) {
+	var refreshes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case tokenPath:
+			atomic.AddInt32(&refreshes, 1)
+			// slow enough for the other requests to wait on this refresh
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(`{"access_token":"fresh","expires_in":3600}`))
+		case "/v1" + playbackPath:
+			if got := req.Header.Get("Authorization"); got != "Bearer fresh" {
+				t.Errorf("authorization is %q", got)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %v", req.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	client, store := newTestClient(srv, "stale", time.Now().Add(-time.Hour))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := client.Playback()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&refreshes); n != 1 {
+		t.Errorf("refreshed %v times, want 1", n)
+	}
+	if token := store.Get(configAccessToken); token != "fresh" {
+		t.Errorf("stored token is %q", token)
+	}
+	if token := store.Get(configRefreshToken); token != "refresh" {
+		t.Errorf("refresh token changed to %q", token)
+	}
+}
+
+func TestPlayerVolume(t *testing.T) {
+	devices := `{"devices":[{"id":"lounge-id","name":"Lounge","type":"Speaker"}]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(devices))
+	}))
+	defer srv.Close()
+
+	client, store := newTestClient(srv, "access", time.Now().Add(time.Hour))
+	store.Set(configPlayer, "kitchen-id")
+	store.Set(configPlayerName, "Kitchen")
+	store.Set(configPlayerFallbacks, "Lounge")
+	store.Set("spotify_volume_kitchen-id", "30")
+
+	// volumes used to be kept by the player's ID
+	if got := client.Policy().Volume; got != 30 {
+		t.Errorf("kitchen volume is %v", got)
+	}
+	if store.Get("spotify_volume_Kitchen") != "30" || store.Get("spotify_volume_kitchen-id") != "" {
+		t.Errorf("volume wasn't moved to the player's name: %v", store.data)
+	}
+
+	// the fallback standing in doesn't get the kitchen's volume
+	err := client.ResolvePlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Policy().Volume; got != -1 {
+		t.Errorf("lounge volume is %v", got)
+	}
+	err = client.SetPolicy(Policy{Shuffle: ShuffleOff, Repeat: RepeatOff, Volume: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Get("spotify_volume_Lounge") != "50" || store.Get("spotify_volume_Kitchen") != "30" {
+		t.Errorf("volumes are %v", store.data)
+	}
+	// the next scan still knows the lounge is only standing in
+	err = client.ResolvePlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Policy().Volume; got != 50 {
+		t.Errorf("lounge volume is %v on the second scan", got)
+	}
+	if got := client.PlayerName(); got != "Kitchen" {
+		t.Errorf("chosen player is %v", got)
+	}
+
+	// the kitchen is back with a new ID
+	devices = `{"devices":[{"id":"lounge-id","name":"Lounge","type":"Speaker"},{"id":"kitchen-2","name":"Kitchen","type":"Speaker"}]}`
+	err = client.ResolvePlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Get(configPlayer) != "kitchen-2" || store.Get(configPlayerStandIn) != "" {
+		t.Errorf("kitchen wasn't chosen again: %v", store.data)
+	}
+	if got := client.Policy().Volume; got != 30 {
+		t.Errorf("kitchen volume is %v once it's back", got)
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client, store := newTestClient(srv, "access", time.Now().Add(time.Hour))
+	store.Set(configPlayer, "kitchen-id")
+
+	// the track may have been queued before the 503, it isn't queued twice
+	err := client.Queue("spotify:track:one")
+	if err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("queue returned %v after %v calls", err, calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	err = client.SetVolume(50)
+	if err != nil || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("volume returned %v after %v calls", err, calls)
+	}
+}
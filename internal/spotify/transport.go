@@ -0,0 +1,179 @@
+package spotify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// refresh tokens a little before they expire so they don't run out mid request
+const tokenLeeway = time.Minute
+
+var (
+	// ErrNotAuthed means there are no tokens, the Spotify account needs to be
+	// set up
+	ErrNotAuthed = errors.New("spotify account not set up")
+
+	// ErrRevoked means Spotify no longer accepts our refresh token, the
+	// account has been logged out and needs to be set up again
+	ErrRevoked = errors.New("spotify access was revoked")
+)
+
+// refreshCall is a token refresh in progress that other requests can wait on
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// authTransport adds the access token to Web API requests. It refreshes the
+// token before it expires and once more if Spotify answers 401 anyway.
+type authTransport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+// apiClient returns an HTTP client that authorises requests for this client,
+// and retries them when they're rate limited
+func (c *Client) apiClient() *http.Client {
+	return &http.Client{
+		Transport: &authTransport{client: c, base: &retryTransport{base: c.HTTPClient.Transport}},
+		Timeout:   c.HTTPClient.Timeout,
+	}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	token, err := t.client.validToken()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := base.RoundTrip(withToken(req, token))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// the token was rejected before it expired, refresh it and try once more
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+	res.Body.Close()
+	log.Println("spotify answered 401, refreshing token")
+
+	err = t.client.refresh(token)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := withToken(req, t.client.Store.Get(configAccessToken))
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return base.RoundTrip(retry)
+}
+
+// withToken copies the request with the access token set, RoundTrippers must
+// not change the original
+func withToken(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	return r
+}
+
+// validToken returns the access token, refreshing it first if it is about to
+// expire
+func (c *Client) validToken() (string, error) {
+	token := c.Store.Get(configAccessToken)
+	if token == "" {
+		return "", ErrNotAuthed
+	}
+
+	expiryTime, err := time.Parse(defaultTimeFormat, c.Store.Get(configExpiry))
+	if err == nil && time.Now().Add(tokenLeeway).Before(expiryTime) {
+		// token has not yet expired, no need to refresh
+		return token, nil
+	}
+
+	log.Println("expiry token expired, refreshing")
+	err = c.refresh(token)
+	if err != nil {
+		return "", err
+	}
+	return c.Store.Get(configAccessToken), nil
+}
+
+// refresh gets a new access token to replace stale. Concurrent callers share
+// one refresh, and nothing is done if another caller has already replaced
+// the stale token.
+func (c *Client) refresh(stale string) error {
+	if c.parent != nil {
+		return c.parent.refresh(stale)
+	}
+
+	c.refreshLock.Lock()
+	if call := c.refreshing; call != nil {
+		c.refreshLock.Unlock()
+		<-call.done
+		return call.err
+	}
+	if c.Store.Get(configAccessToken) != stale {
+		c.refreshLock.Unlock()
+		return nil
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	c.refreshing = call
+	c.refreshLock.Unlock()
+
+	call.err = c.refreshToken()
+
+	c.refreshLock.Lock()
+	c.refreshing = nil
+	c.refreshLock.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+func (c *Client) refreshToken() error {
+	requestTime := time.Now()
+	tokenData, err := c.getRefreshToken(c.Store.Get(configRefreshToken))
+	if tokenErr, ok := err.(*TokenError); ok && tokenErr.Code == "invalid_grant" {
+		log.Println("spotify refresh token revoked, logging out:", tokenErr)
+		c.Logout()
+		return ErrRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if tokenData.AccessToken == "" {
+		return fmt.Errorf("spotify token not returned")
+	}
+	c.Store.Set(configExpiry, requestTime.Add(time.Duration(tokenData.Expiry)*time.Second).Format(defaultTimeFormat))
+	c.Store.Set(configAccessToken, tokenData.AccessToken)
+	if tokenData.RefreshToken != "" {
+		// Spotify may rotate the refresh token, the old one stops working
+		c.Store.Set(configRefreshToken, tokenData.RefreshToken)
+	}
+	if tokenData.Scope != "" {
+		c.Store.Set(configScope, tokenData.Scope)
+	}
+
+	return nil
+}
+
+// Logout forgets the account's tokens so the app asks to set up Spotify again
+func (c *Client) Logout() {
+	c.Store.Set(configAccessToken, "")
+	c.Store.Set(configRefreshToken, "")
+	c.Store.Set(configExpiry, "")
+	c.Store.Set(configScope, "")
+}
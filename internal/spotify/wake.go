@@ -0,0 +1,112 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	transferPath = "/me/player"
+
+	// how long a player gets to start the album, and how often we check
+	startTimeout  = 10 * time.Second
+	startInterval = 500 * time.Millisecond
+)
+
+var (
+	// ErrPlayerUnavailable means the player couldn't be woken up, it's
+	// probably switched off or not connected to Spotify
+	ErrPlayerUnavailable = errors.New("spotify player unavailable")
+
+	// ErrNotStarted means Spotify accepted the album but the player didn't
+	// start playing it in time
+	ErrNotStarted = errors.New("spotify player didn't start playing")
+)
+
+// APIError is the error the Web API returns, Reason is set for player errors
+// such as NO_ACTIVE_DEVICE
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+func (e *APIError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%v %v (%v)", e.Status, e.Message, e.Reason)
+	}
+	return fmt.Sprintf("%v %v", e.Status, e.Message)
+}
+
+// newAPIError reads the error from a Web API response
+func newAPIError(status int, body []byte) error {
+	var data struct {
+		Error *APIError `json:"error"`
+	}
+	if json.Unmarshal(body, &data) == nil && data.Error != nil {
+		if data.Error.Status == 0 {
+			data.Error.Status = status
+		}
+		return data.Error
+	}
+	return fmt.Errorf("bad status code response: %v %v", status, string(body))
+}
+
+// isNotFound reports whether the error says the player isn't active or can't
+// be found, both answered with a 404
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+type transferRequest struct {
+	DeviceIDs []string `json:"device_ids"`
+	Play      bool     `json:"play"`
+}
+
+// TransferPlayback makes the device the account's active player, which wakes
+// up most speakers
+func (c *Client) TransferPlayback(deviceID string) error {
+	b, err := json.Marshal(transferRequest{DeviceIDs: []string{deviceID}})
+	if err != nil {
+		return err
+	}
+
+	res, body, err := c.apiRequest("PUT", transferPath, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		log.Println("unable to transfer playback:", newAPIError(res.StatusCode, body))
+		return ErrPlayerUnavailable
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusAccepted {
+		return newAPIError(res.StatusCode, body)
+	}
+	return nil
+}
+
+// waitForPlayback checks the player until it is playing the album
+func (c *Client) waitForPlayback(uri string) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		state, ok, err := c.Playback()
+		if err != nil {
+			return err
+		}
+		if ok && state.IsPlaying && state.ContextURI() == uri {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("player is on %v, wanted %v", state.ContextURI(), uri)
+			return ErrNotStarted
+		}
+		time.Sleep(startInterval)
+	}
+}
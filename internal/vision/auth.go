@@ -0,0 +1,219 @@
+package vision
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenURL = "https://oauth2.googleapis.com/token"
+	visionScope     = "https://www.googleapis.com/auth/cloud-vision"
+	jwtGrantType    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// refresh tokens a little early so they don't expire mid request
+	tokenLeeway = time.Minute
+	jwtLifetime = time.Hour
+)
+
+// ServiceAccount is the JSON key file downloaded from the GCP console
+type ServiceAccount struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type jwtClaims struct {
+	Issuer   string `json:"iss"`
+	Scope    string `json:"scope"`
+	Audience string `json:"aud"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	Expiry      int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// tokens caches access tokens by key file and token URL so a settings check
+// doesn't reuse the token of the saved settings
+var tokens = struct {
+	sync.Mutex
+	cache map[string]cachedToken
+}{cache: make(map[string]cachedToken)}
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// accessToken returns a cached access token for the service account, fetching
+// a new one if it is missing or about to expire
+func accessToken(client *http.Client, keyFile, tokenURL string) (string, error) {
+	tokens.Lock()
+	defer tokens.Unlock()
+
+	cacheKey := keyFile + "|" + tokenURL
+	cached, ok := tokens.cache[cacheKey]
+	if ok && time.Now().Add(tokenLeeway).Before(cached.expiry) {
+		return cached.token, nil
+	}
+
+	account, err := loadServiceAccount(keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	if tokenURL == "" {
+		tokenURL = firstSet(account.TokenURI, defaultTokenURL)
+	}
+
+	log.Println("fetching vision service account token")
+
+	requestTime := time.Now()
+	assertion, err := signJWT(account, tokenURL, requestTime)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := exchangeJWT(client, tokenURL, assertion)
+	if err != nil {
+		return "", err
+	}
+
+	tokens.cache[cacheKey] = cachedToken{
+		token:  response.AccessToken,
+		expiry: requestTime.Add(time.Duration(response.Expiry) * time.Second),
+	}
+	return response.AccessToken, nil
+}
+
+func loadServiceAccount(keyFile string) (ServiceAccount, error) {
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	var account ServiceAccount
+	err = json.Unmarshal(contents, &account)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return ServiceAccount{}, fmt.Errorf("%v is not a service account key file", keyFile)
+	}
+
+	return account, nil
+}
+
+// signJWT builds the RS256 signed assertion Google exchanges for an access token
+func signJWT(account ServiceAccount, tokenURL string, now time.Time) (string, error) {
+	key, err := parsePrivateKey(account.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(jwtHeader{Algorithm: "RS256", Type: "JWT", KeyID: account.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:   account.ClientEmail,
+		Scope:    visionScope,
+		Audience: tokenURL,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(jwtLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+func exchangeJWT(client *http.Client, tokenURL, assertion string) (TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", jwtGrantType)
+	form.Set("assertion", assertion)
+
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	var response TokenResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("bad token response: %v %v", res.StatusCode, string(body))
+	}
+
+	if res.StatusCode != http.StatusOK || response.AccessToken == "" {
+		return TokenResponse{}, fmt.Errorf("token exchange failed: %v %v %v", res.StatusCode, response.Error, response.ErrorDescription)
+	}
+
+	return response, nil
+}
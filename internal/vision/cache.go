@@ -0,0 +1,203 @@
+package vision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/config"
+)
+
+const (
+	// Config keys, a TTL of "0" turns the cache off
+	configCacheDir   = "vision_cache_dir"
+	configCacheTTL   = "vision_cache_ttl"
+	configCacheMaxMB = "vision_cache_max_mb"
+
+	defaultCacheDir   = "vision-cache"
+	defaultCacheTTL   = 30 * 24 * time.Hour
+	defaultCacheMaxMB = 50
+
+	cacheExt = ".json"
+)
+
+var (
+	cacheLock   sync.Mutex
+	cacheHits   int64
+	cacheMisses int64
+)
+
+// CacheStats returns the number of cache hits and misses since startup
+func CacheStats() (hits, misses int64) {
+	return atomic.LoadInt64(&cacheHits), atomic.LoadInt64(&cacheMisses)
+}
+
+// cachedAnnotate returns the stored Vision response for the request if there
+// is a fresh one, otherwise it calls the API and stores the response
+func cachedAnnotate(s Settings, req BatchAnnotateRequest) (BatchAnnotateResponse, error) {
+	// bundles need to record the call to Google to be replayable elsewhere
+	ttl := cacheTTL()
+	if ttl <= 0 || s.bundle != nil {
+		return meteredAnnotate(s, req)
+	}
+
+	key, err := requestKey(req)
+	if err != nil {
+		return BatchAnnotateResponse{}, err
+	}
+	response, ok := loadCached(key, ttl)
+	if ok {
+		atomic.AddInt64(&cacheHits, 1)
+		log.Println("vision cache hit", key)
+		return response, nil
+	}
+	atomic.AddInt64(&cacheMisses, 1)
+	log.Println("vision cache miss", key)
+
+	response, err = meteredAnnotate(s, req)
+	if err != nil {
+		return response, err
+	}
+
+	// errors for an image might not happen next time
+	for _, r := range response.Responses {
+		if r.Error != nil {
+			return response, nil
+		}
+	}
+
+	err = storeCached(key, response)
+	if err != nil {
+		log.Println("failed to store vision response in cache:", err)
+	}
+
+	return response, nil
+}
+
+// requestKey is the SHA-256 of the request, so changing the features asked
+// for doesn't serve responses to the old request
+func requestKey(req BatchAnnotateRequest) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func loadCached(key string, ttl time.Duration) (BatchAnnotateResponse, bool) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	path := filepath.Join(cacheDir(), key+cacheExt)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return BatchAnnotateResponse{}, false
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return BatchAnnotateResponse{}, false
+	}
+
+	var response BatchAnnotateResponse
+	err = json.Unmarshal(contents, &response)
+	if err != nil {
+		return BatchAnnotateResponse{}, false
+	}
+	return response, true
+}
+
+func storeCached(key string, response BatchAnnotateResponse) error {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	dir := cacheDir()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, key+cacheExt), contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	return prune(dir)
+}
+
+// prune removes expired entries, then the oldest entries until the cache is
+// under its size limit
+func prune(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ttl := cacheTTL()
+	files := []os.FileInfo{}
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != cacheExt {
+			continue
+		}
+		if time.Since(entry.ModTime()) > ttl {
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		files = append(files, entry)
+		total += entry.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	limit := cacheMaxBytes()
+	for len(files) > 0 && total > limit {
+		os.Remove(filepath.Join(dir, files[0].Name()))
+		total -= files[0].Size()
+		files = files[1:]
+	}
+
+	return nil
+}
+
+func cacheDir() string {
+	return firstSet(config.Get(configCacheDir), defaultCacheDir)
+}
+
+func cacheTTL() time.Duration {
+	value := config.Get(configCacheTTL)
+	if value == "" {
+		return defaultCacheTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		log.Println("invalid vision cache TTL:", err)
+		return defaultCacheTTL
+	}
+	return ttl
+}
+
+func cacheMaxBytes() int64 {
+	mb, err := strconv.ParseInt(config.Get(configCacheMaxMB), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = defaultCacheMaxMB
+	}
+	return mb * 1024 * 1024
+}
@@ -0,0 +1,132 @@
+package vision
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The HTTP recognizer protocol lets a self-hosted model recognise records.
+//
+// Autorecord POSTs JSON to the configured URL:
+//
+//	{"image": "<base64 encoded image>", "max_results": 5}
+//
+// and the server answers 200 with candidates, best first:
+//
+//	{"candidates": [{"artist": "Coldplay", "album": "Parachutes", "score": 0.93}]}
+//
+// Each candidate has an artist and album when the server knows them, otherwise
+// a free "text" description which is split like a Google best guess label.
+// Scores run from 0 to 1 and are compared against the confidence threshold.
+// Any other status is an error, with an optional {"error": "message"} body.
+//
+// An empty image is sent to check the server is reachable, it should answer
+// 200 with no candidates.
+
+const (
+	defaultRecognizerTimeout = 10 * time.Second
+	httpMaxResults           = 5
+)
+
+type RecognizeRequest struct {
+	Image      string `json:"image"`
+	MaxResults int    `json:"max_results"`
+}
+
+type RecognizeResponse struct {
+	Candidates []Candidate `json:"candidates"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// HTTPRecognizer speaks the HTTP recognizer protocol to a server
+type HTTPRecognizer struct {
+	URL     string
+	Timeout time.Duration
+	// Transport sends the requests, http.DefaultTransport when nil
+	Transport http.RoundTripper
+}
+
+func (s Settings) httpRecognizer() HTTPRecognizer {
+	timeout, err := time.ParseDuration(s.RecognizerTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = defaultRecognizerTimeout
+	}
+	return HTTPRecognizer{URL: s.RecognizerURL, Timeout: timeout, Transport: s.httpClient().Transport}
+}
+
+func (h HTTPRecognizer) Recognize(imageData string, knownArtists []string) ([]Candidate, error) {
+	if imageData == "" {
+		return nil, errors.New("no image")
+	}
+
+	response, err := h.call(imageData)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []Candidate{}
+	for _, c := range response.Candidates {
+		if c.Artist == "" && c.Album == "" {
+			parsed := ParseLabel(c.Text, knownArtists)
+			c.Artist, c.Album = parsed.Artist, parsed.Album
+		}
+		if c.Source == "" {
+			c.Source = h.URL
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	if len(candidates) == 0 {
+		return nil, errors.New("no guesses")
+	}
+	return candidates, nil
+}
+
+// Check sends an empty image to make sure the server speaks the protocol
+func (h HTTPRecognizer) Check() error {
+	_, err := h.call("")
+	return err
+}
+
+func (h HTTPRecognizer) call(imageData string) (RecognizeResponse, error) {
+	reqBuf, err := json.Marshal(RecognizeRequest{Image: imageData, MaxResults: httpMaxResults})
+	if err != nil {
+		return RecognizeResponse{}, err
+	}
+
+	client := http.Client{Transport: h.Transport, Timeout: h.Timeout}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewBuffer(reqBuf))
+	if err != nil {
+		return RecognizeResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return RecognizeResponse{}, err
+	}
+
+	var response RecognizeResponse
+	err = json.Unmarshal(respBytes, &response)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && response.Error != "" {
+			return RecognizeResponse{}, fmt.Errorf("recognizer error %v: %v", resp.StatusCode, response.Error)
+		}
+		return RecognizeResponse{}, fmt.Errorf("bad status code response: %v %v", resp.StatusCode, string(respBytes))
+	}
+	if err != nil {
+		return RecognizeResponse{}, err
+	}
+
+	return response, nil
+}
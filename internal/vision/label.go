@@ -0,0 +1,129 @@
+package vision
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Parse confidences, from an artist we've played before down to a label we
+// couldn't split at all
+const (
+	knownArtistConfidence = 0.9
+	quotedConfidence      = 0.8
+	byConfidence          = 0.7
+	dashConfidence        = 0.6
+	unparsedConfidence    = 0.3
+)
+
+var (
+	quotedAlbumFirst  = regexp.MustCompile(`^["“'‘](.+?)["”'’]\s+by\s+(.+)$`)
+	quotedArtistFirst = regexp.MustCompile(`^(.+?)\s+["“'‘](.+?)["”'’]$`)
+	byPattern         = regexp.MustCompile(`(?i)^(.+?)\s+by\s+(.+)$`)
+	dashPattern       = regexp.MustCompile(`^(.+?)\s+[-–—:]\s+(.+)$`)
+
+	// words that describe the picture rather than the record, only at the end
+	// so titles like "The Art of Noise" are left alone
+	labelNoise = regexp.MustCompile(`(?i)(\s+(vinyl|lp|cd|album cover|album art|album artwork|cover art|artwork|12"|12 inch))+$`)
+)
+
+// ParseLabel splits a free text label, such as the best guess from Vision, into
+// an artist and album. Artists we've played before are matched first. The
+// candidate's Score is how confident we are in the split.
+func ParseLabel(label string, knownArtists []string) Candidate {
+	text := strings.TrimSpace(whitespacePattern.ReplaceAllString(label, " "))
+	text = labelNoise.ReplaceAllString(text, "")
+	c := Candidate{Text: text, Score: unparsedConfidence}
+	if text == "" {
+		return c
+	}
+
+	if artist, album, ok := splitKnownArtist(text, knownArtists); ok {
+		c.Artist, c.Album, c.Score = artist, album, knownArtistConfidence
+		return c
+	}
+
+	if m := quotedAlbumFirst.FindStringSubmatch(text); m != nil {
+		c.Artist, c.Album, c.Score = m[2], m[1], quotedConfidence
+		return c
+	}
+
+	if m := quotedArtistFirst.FindStringSubmatch(text); m != nil {
+		c.Artist, c.Album, c.Score = m[1], m[2], quotedConfidence
+		return c
+	}
+
+	// "Stand By Me" isn't by "Me", so searches only trust this split when
+	// Spotify finds it and fall back to the whole text
+	if m := byPattern.FindStringSubmatch(text); m != nil {
+		c.Artist, c.Album, c.Score = m[2], cleanAlbum(m[1]), byConfidence
+		return c
+	}
+
+	if m := dashPattern.FindStringSubmatch(text); m != nil {
+		// "Artist - Album" is the usual order, but flip it if the right hand
+		// side is an artist we know
+		artist, album := m[1], m[2]
+		if isKnownArtist(album, knownArtists) {
+			artist, album = album, artist
+		}
+		c.Artist, c.Album, c.Score = artist, cleanAlbum(album), dashConfidence
+		return c
+	}
+
+	return c
+}
+
+// splitKnownArtist looks for the longest known artist at the start or end of
+// the text and treats the rest as the album
+func splitKnownArtist(text string, knownArtists []string) (string, string, bool) {
+	artists := append([]string{}, knownArtists...)
+	sort.Slice(artists, func(i, j int) bool {
+		return len(artists[i]) > len(artists[j])
+	})
+
+	for _, artist := range artists {
+		n := len(artist)
+		if n == 0 || n >= len(text) {
+			continue
+		}
+
+		var rest string
+		switch {
+		case strings.EqualFold(text[:n], artist) && text[n] == ' ':
+			rest = text[n:]
+		case strings.EqualFold(text[len(text)-n:], artist) && text[len(text)-n-1] == ' ':
+			rest = text[:len(text)-n]
+		default:
+			continue
+		}
+
+		// "Parachutes by Coldplay" leaves "by" behind
+		rest = strings.Trim(rest, " -–—:")
+		rest = strings.TrimSpace(strings.TrimSuffix(rest, " by"))
+		if album := cleanAlbum(rest); album != "" {
+			return artist, album, true
+		}
+	}
+	return "", "", false
+}
+
+func isKnownArtist(name string, knownArtists []string) bool {
+	for _, artist := range knownArtists {
+		if strings.EqualFold(strings.TrimSpace(name), artist) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanAlbum removes quotes and words like "vinyl" and "album cover" from the
+// end of an album name
+func cleanAlbum(album string) string {
+	cleaned := labelNoise.ReplaceAllString(strings.TrimSpace(album), "")
+	cleaned = strings.TrimSpace(whitespacePattern.ReplaceAllString(cleaned, " "))
+	if cleaned == "" {
+		cleaned = strings.TrimSpace(album)
+	}
+	return strings.Trim(cleaned, `"“”'‘’`)
+}
@@ -0,0 +1,208 @@
+package vision
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pageRule turns the title of a page on a known music site into an artist and
+// album
+type pageRule struct {
+	name   string
+	host   string
+	weight float64
+	parse  func(title string) (artist, album string, ok bool)
+}
+
+var pageRules = []pageRule{
+	{
+		// "Coldplay – Parachutes | Releases | Discogs"
+		// "Coldplay - Parachutes (2000, Vinyl) | Discogs"
+		name:   "discogs",
+		host:   "discogs.com",
+		weight: 1,
+		parse:  artistFirst(regexp.MustCompile(`^(.+?) [–-] (.+?)(?: \([^)]*\))*(?: \|.*)?$`)),
+	},
+	{
+		// "Parachutes | Coldplay"
+		name:   "bandcamp",
+		host:   "bandcamp.com",
+		weight: 0.9,
+		parse:  albumFirst(regexp.MustCompile(`^(.+?) \| (.+?)$`)),
+	},
+	{
+		// "Parachutes - Coldplay | Album | AllMusic"
+		name:   "allmusic",
+		host:   "allmusic.com",
+		weight: 0.9,
+		parse:  albumFirst(regexp.MustCompile(`^(.+?) - (.+?) \| Album \| AllMusic$`)),
+	},
+	{
+		// "Parachutes by Coldplay (Album, Britpop): Reviews, Ratings, Credits..."
+		name:   "rateyourmusic",
+		host:   "rateyourmusic.com",
+		weight: 0.9,
+		parse:  albumFirst(regexp.MustCompile(`^(.+?) by (.+?) \((?:Album|EP)[^)]*\)`)),
+	},
+	{
+		// "Parachutes - Album by Coldplay - Apple Music"
+		name:   "apple music",
+		host:   "music.apple.com",
+		weight: 0.9,
+		parse:  albumFirst(regexp.MustCompile(`^(.+?) - (?:Album|EP|Single) by (.+?) - Apple Music$`)),
+	},
+	{
+		// "Parachutes - Album by Coldplay | Spotify"
+		name:   "spotify",
+		host:   "open.spotify.com",
+		weight: 0.9,
+		parse:  albumFirst(regexp.MustCompile(`^(.+?) - (?:Album|EP|Single) by (.+?) \| Spotify$`)),
+	},
+	{
+		// "Parachutes (Coldplay album) - Wikipedia" or "Parachutes (album) - Wikipedia"
+		name:   "wikipedia",
+		host:   "wikipedia.org",
+		weight: 0.8,
+		parse:  wikipediaTitle,
+	},
+}
+
+var (
+	tagPattern        = regexp.MustCompile(`<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+	discogsIndex      = regexp.MustCompile(` \(\d+\)$`)
+	wikipediaPattern  = regexp.MustCompile(`^(.+?) \((?:(.+?) )?album\) - Wikipedia$`)
+)
+
+func artistFirst(pattern *regexp.Regexp) func(string) (string, string, bool) {
+	return func(title string) (string, string, bool) {
+		m := pattern.FindStringSubmatch(title)
+		if m == nil {
+			return "", "", false
+		}
+		return m[1], m[2], true
+	}
+}
+
+func albumFirst(pattern *regexp.Regexp) func(string) (string, string, bool) {
+	return func(title string) (string, string, bool) {
+		m := pattern.FindStringSubmatch(title)
+		if m == nil {
+			return "", "", false
+		}
+		return m[2], m[1], true
+	}
+}
+
+func wikipediaTitle(title string) (string, string, bool) {
+	m := wikipediaPattern.FindStringSubmatch(title)
+	if m == nil {
+		return "", "", false
+	}
+	return m[2], m[1], true
+}
+
+// pageCandidates parses the titles of matching pages with the site rules.
+// Pages that agree on the same record are merged, so records found on several
+// sites score higher.
+func pageCandidates(pages []WebPage) []Candidate {
+	found := map[string]*Candidate{}
+	order := []string{}
+	parsed := 0
+
+	for _, page := range pages {
+		rule, ok := ruleFor(page.URL)
+		if !ok {
+			continue
+		}
+
+		artist, album, ok := rule.parse(cleanTitle(page.PageTitle))
+		if !ok {
+			continue
+		}
+		artist = cleanArtist(artist)
+		album = strings.TrimSpace(album)
+		if album == "" {
+			continue
+		}
+		parsed++
+
+		key := strings.ToLower(artist + "|" + album)
+		c, ok := found[key]
+		if !ok {
+			c = &Candidate{Artist: artist, Album: album, Source: rule.name}
+			found[key] = c
+			order = append(order, key)
+		}
+		c.Score += rule.weight
+	}
+
+	// an album without an artist backs up the same album with one
+	for _, key := range order {
+		c := found[key]
+		if c.Artist != "" {
+			continue
+		}
+		for _, other := range order {
+			o := found[other]
+			if o.Artist != "" && strings.EqualFold(o.Album, c.Album) {
+				o.Score += c.Score
+				delete(found, key)
+				break
+			}
+		}
+	}
+
+	candidates := []Candidate{}
+	for _, key := range order {
+		if found[key] == nil {
+			continue
+		}
+		c := *found[key]
+		// the share of parsed pages agreeing on this record, lifted so a
+		// single trusted page still beats the best guess label
+		c.Score = 0.5 + 0.5*c.Score/float64(parsed)
+		if c.Artist == "" {
+			// the album on its own is a weaker match
+			c.Score *= 0.8
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+func ruleFor(pageURL string) (pageRule, bool) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return pageRule{}, false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, rule := range pageRules {
+		if host == rule.host || strings.HasSuffix(host, "."+rule.host) {
+			return rule, true
+		}
+	}
+	return pageRule{}, false
+}
+
+// cleanTitle removes the highlighting tags and entities Vision leaves in titles
+func cleanTitle(title string) string {
+	title = tagPattern.ReplaceAllString(title, "")
+	title = html.UnescapeString(title)
+	title = whitespacePattern.ReplaceAllString(title, " ")
+	return strings.TrimSpace(title)
+}
+
+// cleanArtist removes Discogs' disambiguation, e.g. "Nirvana (2)" and "Prince*"
+func cleanArtist(artist string) string {
+	artist = discogsIndex.ReplaceAllString(strings.TrimSpace(artist), "")
+	return strings.TrimSpace(strings.TrimRight(artist, "*"))
+}
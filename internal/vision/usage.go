@@ -0,0 +1,142 @@
+package vision
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/config"
+)
+
+const (
+	// Config keys, an empty or zero budget means no limit
+	configBudget  = "vision_monthly_budget"
+	configWarning = "vision_monthly_warning"
+
+	usageFile = "vision-usage.txt"
+
+	// warn at 80% of the budget unless configured otherwise
+	defaultWarningPercent = 80
+
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+)
+
+// ErrBudgetExceeded is returned instead of calling Google once this month's
+// calls reach the configured budget
+var ErrBudgetExceeded = errors.New("google vision monthly budget reached")
+
+var (
+	usage     = config.New(usageFile)
+	usageLock sync.Mutex
+)
+
+// UsageReport is the number of billable Vision calls made
+type UsageReport struct {
+	Today   int
+	Month   int
+	Budget  int
+	Warning int
+}
+
+// Warn reports whether this month's calls have passed the warning threshold
+func (u UsageReport) Warn() bool {
+	return u.Warning > 0 && u.Month >= u.Warning
+}
+
+// Exceeded reports whether this month's calls have reached the budget
+func (u UsageReport) Exceeded() bool {
+	return u.Budget > 0 && u.Month >= u.Budget
+}
+
+// Usage returns the call counts for today and this month with the limits
+func Usage() UsageReport {
+	now := time.Now()
+	report := UsageReport{
+		Today:  readCount(now.Format(dayFormat)),
+		Month:  readCount(now.Format(monthFormat)),
+		Budget: readInt(config.Get(configBudget)),
+	}
+
+	report.Warning = readInt(config.Get(configWarning))
+	if report.Warning == 0 && report.Budget > 0 {
+		report.Warning = report.Budget * defaultWarningPercent / 100
+	}
+
+	return report
+}
+
+// SetBudget stores the monthly budget and warning threshold, zero removes them
+func SetBudget(budget, warning int) {
+	config.Set(configBudget, strconv.Itoa(budget))
+	config.Set(configWarning, strconv.Itoa(warning))
+}
+
+// checkBudget is called before every billable call
+func checkBudget() error {
+	if Usage().Exceeded() {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// meteredAnnotate refuses to call Google once the budget is used up and counts
+// the calls it does make. Replayed calls don't reach Google so aren't counted.
+func meteredAnnotate(s Settings, req BatchAnnotateRequest) (BatchAnnotateResponse, error) {
+	if s.replaying() {
+		return annotate(s, req)
+	}
+
+	err := checkBudget()
+	if err != nil {
+		return BatchAnnotateResponse{}, err
+	}
+
+	response, err := annotate(s, req)
+	if err != nil {
+		return response, err
+	}
+
+	recordCall()
+	return response, nil
+}
+
+// recordCall counts a billable call against today and this month
+func recordCall() {
+	usageLock.Lock()
+	defer usageLock.Unlock()
+
+	now := time.Now()
+	pruneDays(now)
+	for _, key := range []string{now.Format(dayFormat), now.Format(monthFormat)} {
+		usage.Set(key, strconv.Itoa(readCount(key)+1))
+	}
+}
+
+// pruneDays drops the daily counts from before this month, only today's is
+// shown and the monthly totals are kept. It's written out with the next count.
+func pruneDays(now time.Time) {
+	month := now.Format(monthFormat)
+
+	usage.Lock()
+	defer usage.Unlock()
+	for key := range usage.Data {
+		day, err := time.Parse(dayFormat, key)
+		if err == nil && day.Format(monthFormat) != month {
+			delete(usage.Data, key)
+		}
+	}
+}
+
+func readCount(key string) int {
+	return readInt(usage.Get(key))
+}
+
+func readInt(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -6,8 +6,51 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/jccroft1/autorecord/internal/bundle"
+	"github.com/jccroft1/autorecord/internal/config"
+)
+
+const (
+	// Environment names, these override the config store when set
+	envURL     = "AR_VISION_URL"
+	envAPIKey  = "AR_API_KEY"
+	envKeyFile = "GOOGLE_APPLICATION_CREDENTIALS"
+
+	// Config keys
+	configURL               = "vision_url"
+	configAuth              = "vision_auth"
+	configAPIKey            = "vision_api_key"
+	configKeyFile           = "vision_service_account"
+	configTokenURL          = "vision_token_url"
+	configRecognizer        = "vision_recognizer"
+	configRecognizerURL     = "vision_recognizer_url"
+	configRecognizerTimeout = "vision_recognizer_timeout"
+
+	// Auth methods
+	AuthAPIKey         = "api_key"
+	AuthServiceAccount = "service_account"
+
+	// Recognizers
+	RecognizerGoogle = "google"
+	RecognizerHTTP   = "http"
+
+	// Google Vision API
+	defaultURL  = "https://vision.googleapis.com"
+	annotateURL = "%v/v1/images:annotate"
+
+	// enough results to get a few matching pages back
+	maxResults = 10
+
+	// the best guess label is a description of the image, not necessarily
+	// the record, so it ranks below matches from music sites
+	bestGuessScore = 0.5
 )
 
 type BatchAnnotateRequest struct {
@@ -34,11 +77,15 @@ type BatchAnnotateResponse struct {
 
 type AnnotateResponse struct {
 	Result WebDetection `json:"webDetection"`
+	Error  *APIError    `json:"error,omitempty"`
 }
 
 type WebDetection struct {
-	BestGuessLabels []BestGuessLabel `json:"bestGuessLabels"`
-	WebEntities     []WebEntity      `json:"webEntities"`
+	BestGuessLabels         []BestGuessLabel `json:"bestGuessLabels"`
+	WebEntities             []WebEntity      `json:"webEntities"`
+	FullMatchingImages      []WebImage       `json:"fullMatchingImages"`
+	PartialMatchingImages   []WebImage       `json:"partialMatchingImages"`
+	PagesWithMatchingImages []WebPage        `json:"pagesWithMatchingImages"`
 }
 
 type BestGuessLabel struct {
@@ -49,12 +96,245 @@ type BestGuessLabel struct {
 type WebEntity struct {
 	EntityID    string  `json:"entityId"`
 	Score       float32 `json:"score"`
-	Description string  `json"description"`
+	Description string  `json:"description"`
+}
+
+type WebImage struct {
+	URL   string  `json:"url"`
+	Score float32 `json:"score"`
+}
+
+type WebPage struct {
+	URL                   string     `json:"url"`
+	Score                 float32    `json:"score"`
+	PageTitle             string     `json:"pageTitle"`
+	FullMatchingImages    []WebImage `json:"fullMatchingImages"`
+	PartialMatchingImages []WebImage `json:"partialMatchingImages"`
 }
 
-// Search takes base64 encoded image data and returns the first web detection result
+// Candidate is a possible match for the scanned record. Artist and Album are
+// set when we know them, otherwise Text holds a free text description.
+type Candidate struct {
+	Artist string  `json:"artist,omitempty"`
+	Album  string  `json:"album,omitempty"`
+	Text   string  `json:"text,omitempty"`
+	Score  float64 `json:"score"`
+	Source string  `json:"source,omitempty"`
+}
+
+// Recognizer finds candidates for a base64 encoded image
+type Recognizer interface {
+	Recognize(imageData string, knownArtists []string) ([]Candidate, error)
+}
+
+// Query returns the candidate as search text
+func (c Candidate) Query() string {
+	if c.Artist != "" || c.Album != "" {
+		return strings.TrimSpace(c.Album + " " + c.Artist)
+	}
+	return c.Text
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// Settings holds which recognizer to use and how to reach it. The HTTP
+// recognizer is also used when Google is chosen but its budget is used up.
+type Settings struct {
+	Recognizer        string
+	BaseURL           string
+	Auth              string
+	APIKey            string
+	KeyFile           string
+	TokenURL          string
+	RecognizerURL     string
+	RecognizerTimeout string
+
+	// bundle records or replays a scan's calls, see WithBundle
+	bundle *bundle.Bundle
+}
+
+// WithBundle returns the settings for a scan that records into or replays
+// from the bundle. The cache is skipped so every call is in the bundle, and
+// replayed calls aren't counted against the budget.
+func (s Settings) WithBundle(b *bundle.Bundle) Settings {
+	s.bundle = b
+	return s
+}
+
+func (s Settings) httpClient() *http.Client {
+	if s.bundle != nil {
+		return s.bundle.Client()
+	}
+	return http.DefaultClient
+}
+
+func (s Settings) replaying() bool {
+	return s.bundle != nil && s.bundle.Replaying()
+}
+
+// CurrentSettings returns the Vision settings, the environment takes priority
+// over the config store
+func CurrentSettings() Settings {
+	s := Settings{
+		Recognizer:        firstSet(config.Get(configRecognizer), RecognizerGoogle),
+		BaseURL:           firstSet(os.Getenv(envURL), config.Get(configURL), defaultURL),
+		Auth:              firstSet(config.Get(configAuth), AuthAPIKey),
+		APIKey:            firstSet(os.Getenv(envAPIKey), config.Get(configAPIKey)),
+		KeyFile:           firstSet(os.Getenv(envKeyFile), config.Get(configKeyFile)),
+		TokenURL:          config.Get(configTokenURL),
+		RecognizerURL:     config.Get(configRecognizerURL),
+		RecognizerTimeout: config.Get(configRecognizerTimeout),
+	}
+	s.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	return s
+}
+
+// EnvOverride reports whether the settings are fixed by the environment
+func EnvOverride() bool {
+	return os.Getenv(envURL) != "" || os.Getenv(envAPIKey) != "" || os.Getenv(envKeyFile) != ""
+}
+
+func IsConfigured() bool {
+	s := CurrentSettings()
+	if s.Recognizer == RecognizerHTTP {
+		return s.RecognizerURL != ""
+	}
+	if s.Auth == AuthServiceAccount {
+		return s.KeyFile != ""
+	}
+	return s.APIKey != ""
+}
+
+// SaveSettings checks the given settings with a test call and stores them
+func SaveSettings(s Settings) error {
+	s.BaseURL = strings.TrimSuffix(strings.TrimSpace(s.BaseURL), "/")
+	if s.BaseURL == "" {
+		s.BaseURL = defaultURL
+	}
+	s.APIKey = strings.TrimSpace(s.APIKey)
+	if s.APIKey == "" {
+		// the settings page never shows the key, a blank one keeps what's saved
+		s.APIKey = config.Get(configAPIKey)
+	}
+	s.KeyFile = strings.TrimSpace(s.KeyFile)
+	s.TokenURL = strings.TrimSpace(s.TokenURL)
+	s.RecognizerURL = strings.TrimSpace(s.RecognizerURL)
+	s.RecognizerTimeout = strings.TrimSpace(s.RecognizerTimeout)
+
+	if s.RecognizerTimeout != "" {
+		_, err := time.ParseDuration(s.RecognizerTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid recognizer timeout: %v", err)
+		}
+	}
+
+	switch s.Recognizer {
+	case RecognizerGoogle:
+		switch s.Auth {
+		case AuthAPIKey:
+			if s.APIKey == "" && os.Getenv(envAPIKey) == "" {
+				return fmt.Errorf("an API key is required")
+			}
+		case AuthServiceAccount:
+			if s.KeyFile == "" {
+				return fmt.Errorf("a service account key file is required")
+			}
+		default:
+			return fmt.Errorf("unknown auth method: %v", s.Auth)
+		}
+	case RecognizerHTTP:
+		if s.RecognizerURL == "" {
+			return fmt.Errorf("a recognizer URL is required")
+		}
+	default:
+		return fmt.Errorf("unknown recognizer: %v", s.Recognizer)
+	}
+
+	// check with the key that will be used, the environment's if it's set,
+	// but only ever store the one given here
+	check := s
+	check.APIKey = firstSet(os.Getenv(envAPIKey), s.APIKey)
+	err := Validate(check)
+	if err != nil {
+		return err
+	}
+
+	config.Set(configRecognizer, s.Recognizer)
+	config.Set(configURL, s.BaseURL)
+	config.Set(configAuth, s.Auth)
+	config.Set(configAPIKey, s.APIKey)
+	config.Set(configKeyFile, s.KeyFile)
+	config.Set(configTokenURL, s.TokenURL)
+	config.Set(configRecognizerURL, s.RecognizerURL)
+	config.Set(configRecognizerTimeout, s.RecognizerTimeout)
+	return nil
+}
+
+// Validate makes an empty request to the chosen recognizer to check the
+// settings work. Empty requests aren't billed by Google.
+func Validate(s Settings) error {
+	if s.Recognizer == RecognizerHTTP {
+		return s.httpRecognizer().Check()
+	}
+	_, err := annotate(s, BatchAnnotateRequest{Requests: []AnnotateRequest{}})
+	return err
+}
+
+func firstSet(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Search takes base64 encoded image data and returns the best candidate as text
 func Search(imageData string) (string, error) {
+	candidates, err := Recognize(imageData, nil)
+	if err != nil {
+		return "", err
+	}
+	return candidates[0].Query(), nil
+}
+
+// Recognize takes base64 encoded image data and returns candidates ranked by
+// score from the configured recognizer
+func Recognize(imageData string, knownArtists []string) ([]Candidate, error) {
+	return CurrentSettings().Recognize(imageData, knownArtists)
+}
+
+// Recognize returns candidates ranked by score from the recognizer in the
+// settings
+func (s Settings) Recognize(imageData string, knownArtists []string) ([]Candidate, error) {
+	if s.Recognizer == RecognizerHTTP {
+		return s.httpRecognizer().Recognize(imageData, knownArtists)
+	}
+
+	candidates, err := GoogleRecognizer{Settings: s}.Recognize(imageData, knownArtists)
+	if err == ErrBudgetExceeded && s.RecognizerURL != "" {
+		log.Println("google vision budget reached, using", s.RecognizerURL)
+		return s.httpRecognizer().Recognize(imageData, knownArtists)
+	}
+	return candidates, err
+}
+
+// GoogleRecognizer uses Google Vision web detection. Matching pages from known
+// music sites come before the best guess label, which is split into artist and
+// album using the known artists.
+type GoogleRecognizer struct {
+	Settings Settings
+}
 
+func (g GoogleRecognizer) Recognize(imageData string, knownArtists []string) ([]Candidate, error) {
 	req := BatchAnnotateRequest{
 		Requests: []AnnotateRequest{
 			{
@@ -64,47 +344,96 @@ func Search(imageData string) (string, error) {
 				Features: []Feature{
 					{
 						FeatureType: "WEB_DETECTION",
-						Max:         1,
+						Max:         maxResults,
 					},
 				},
 			},
 		},
 	}
-	// fmt.Println(req)
 
+	response, err := cachedAnnotate(g.Settings, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Responses) == 0 {
+		return nil, errors.New("no results")
+	}
+	if e := response.Responses[0].Error; e != nil {
+		return nil, fmt.Errorf("vision error %v: %v", e.Code, e.Message)
+	}
+
+	detection := response.Responses[0].Result
+	candidates := pageCandidates(detection.PagesWithMatchingImages)
+	for _, label := range detection.BestGuessLabels {
+		c := ParseLabel(label.Label, knownArtists)
+		if c.Artist != "" {
+			// a label we could split is a little more trustworthy
+			c.Score = bestGuessScore + 0.2*c.Score
+		} else {
+			c.Score = bestGuessScore
+		}
+		c.Source = "best guess"
+		candidates = append(candidates, c)
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New("no guesses")
+	}
+	return candidates, nil
+}
+
+func annotate(s Settings, req BatchAnnotateRequest) (BatchAnnotateResponse, error) {
 	reqBuf, err := json.Marshal(req)
 	if err != nil {
-		return "", err
+		return BatchAnnotateResponse{}, err
 	}
 
-	apiKey := os.Getenv("AR_API_KEY")
-	apiURL := fmt.Sprintf("https://vision.googleapis.com/v1/images:annotate?key=%v", apiKey)
+	apiURL := fmt.Sprintf(annotateURL, s.BaseURL)
+	httpReq, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(reqBuf))
+	if err != nil {
+		return BatchAnnotateResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(reqBuf))
-	// resp, err := http.Post("http://localhost:8000", "application/json", bytes.NewBuffer(reqBuf))
+	switch {
+	case s.Auth == AuthServiceAccount && s.replaying():
+		// the recorded responses don't check the token, and there may not be a
+		// key file to get one with
+	case s.Auth == AuthServiceAccount:
+		token, err := accessToken(s.httpClient(), s.KeyFile, s.TokenURL)
+		if err != nil {
+			return BatchAnnotateResponse{}, fmt.Errorf("unable to get service account token: %v", err)
+		}
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	default:
+		httpReq.URL.RawQuery = url.Values{"key": []string{s.APIKey}}.Encode()
+	}
+
+	resp, err := s.httpClient().Do(httpReq)
 	if err != nil {
-		return "", err
+		return BatchAnnotateResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	respBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return BatchAnnotateResponse{}, err
 	}
 
-	var response BatchAnnotateResponse
+	if resp.StatusCode != http.StatusOK {
+		var errResponse ErrorResponse
+		if json.Unmarshal(respBytes, &errResponse) == nil && errResponse.Error.Message != "" {
+			return BatchAnnotateResponse{}, fmt.Errorf("vision error %v: %v", resp.StatusCode, errResponse.Error.Message)
+		}
+		return BatchAnnotateResponse{}, fmt.Errorf("bad status code response: %v %v", resp.StatusCode, string(respBytes))
+	}
 
+	var response BatchAnnotateResponse
 	err = json.Unmarshal(respBytes, &response)
 	if err != nil {
-		return "", err
-	}
-
-	if len(response.Responses) == 0 {
-		return "", errors.New("no results")
+		return BatchAnnotateResponse{}, err
 	}
 
-	if len(response.Responses[0].Result.BestGuessLabels) == 0 {
-		return "", errors.New("no guesses")
-	}
-	return response.Responses[0].Result.BestGuessLabels[0].Label, nil
+	return response, nil
 }
@@ -0,0 +1,48 @@
+package vision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/images:annotate":
+			if req.URL.Query().Get("key") != "good" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"code":400,"message":"API key not valid","status":"INVALID_ARGUMENT"}}`))
+				return
+			}
+			w.Write([]byte(`{"responses":[]}`))
+		case "/recognize":
+			w.Write([]byte(`{"candidates":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	google := Settings{Recognizer: RecognizerGoogle, BaseURL: srv.URL, Auth: AuthAPIKey, APIKey: "good"}
+	if err := Validate(google); err != nil {
+		t.Errorf("good key: %v", err)
+	}
+
+	google.APIKey = "bad"
+	err := Validate(google)
+	if err == nil || !strings.Contains(err.Error(), "API key not valid") {
+		t.Errorf("bad key: %v", err)
+	}
+
+	recognizer := Settings{Recognizer: RecognizerHTTP, RecognizerURL: srv.URL + "/recognize"}
+	if err := Validate(recognizer); err != nil {
+		t.Errorf("recognizer: %v", err)
+	}
+
+	recognizer.RecognizerURL = srv.URL + "/missing"
+	if err := Validate(recognizer); err == nil {
+		t.Error("missing recognizer validated")
+	}
+}
@@ -16,6 +16,7 @@ const askTpl = `
     <!-- Bootstrap CSS -->
     <link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/bootstrap@4.5.3/dist/css/bootstrap.min.css" integrity="sha384-TX8t27EcRE3e/ihU7zmQxVncDAy5uIKz4rEkgIXeMed4M0jlfIDPvg6uqKI2xXr2" crossorigin="anonymous">
     <base href="http://autorecord.local" />
+    {{with .Refresh}}<meta http-equiv="refresh" content="{{.Seconds}}; url={{.URL}}">{{end}}
 
     <title>Auto Record</title>
   </head>
@@ -44,12 +45,15 @@ const askTpl = `
             <div class="container">
                <h1 class="jumbotron-heading">{{.Title}}</h1>
 
+               {{range .Alerts}}<div class="alert alert-{{.Level}}" role="alert">{{.Text}}</div>{{end}}
+
                {{if .ShowButton}}<a href="/do" class="btn btn-primary my-2">Scan it!</a> {{end}}
 
                 <div class="row">
                     {{range $index, $item := .Items}}
                     <div class="col-sm-6">
                         <div class="card">
+                            {{if $item.Image}}<img src="{{ $item.Image }}" class="card-img-top" alt="{{ $item.Text }}">{{end}}
                             <div class="card-body">
                                 <p class="card-text">{{ $item.Text }}</p>
                                 <a href="{{ $item.Path }}" class="btn btn-primary">Let's do it!</a>
@@ -58,6 +62,120 @@ const askTpl = `
                     </div>
                     {{end}}
                 </div>
+
+                {{with .NowPlaying}}
+                <div class="card mx-auto text-left" style="max-width: 30rem;" id="now-playing" data-refresh="{{.Refresh}}">
+                    <img id="np-image" src="{{.ImageURL}}" class="card-img-top" alt="{{.Album}}" {{if not .ImageURL}}hidden{{end}}>
+                    <div class="card-body">
+                        <h5 class="card-title" id="np-track">{{if .Active}}{{.Track}}{{else}}Nothing is playing{{end}}</h5>
+                        <p class="card-text"><span id="np-artists">{{.Artists}}</span> <span class="text-muted" id="np-album">{{.Album}}</span></p>
+                        <div class="progress mb-2"><div class="progress-bar" id="np-progress" role="progressbar" style="width: {{.Percent}}%"></div></div>
+                        <p class="card-text"><small class="text-muted"><span id="np-time"></span> <span id="np-device">{{.Device}}</span></small></p>
+                    </div>
+                </div>
+                <div class="d-flex flex-wrap justify-content-center my-3" id="np-controls">
+                    {{range .Controls}}
+                    <form action="{{.Action}}" method="post" class="form-inline m-1">
+                        {{if .Input}}<input type="number" class="form-control mr-1" style="width: 6rem;" name="{{.Input}}" value="{{.Value}}" aria-label="{{.Label}}">{{end}}
+                        <button type="submit" class="btn {{if .Active}}btn-primary{{else}}btn-outline-primary{{end}}">{{.Label}}</button>
+                    </form>
+                    {{end}}
+                </div>
+                <script>
+                (function () {
+                    var state = null, fetched = 0, shown = null;
+                    function text(id, value) { document.getElementById(id).textContent = value; }
+                    function time(ms) {
+                        var s = Math.floor(ms / 1000);
+                        return Math.floor(s / 60) + ":" + ("0" + s % 60).slice(-2);
+                    }
+                    function render() {
+                        if (!state) { return; }
+                        var progress = state.progress_ms;
+                        if (state.is_playing) { progress = Math.min(state.duration_ms, progress + Date.now() - fetched); }
+                        var image = document.getElementById("np-image");
+                        image.hidden = !state.image_url;
+                        if (state.image_url && image.getAttribute("src") !== state.image_url) { image.src = state.image_url; }
+                        text("np-track", state.active ? state.track : "Nothing is playing");
+                        text("np-artists", state.artists);
+                        text("np-album", state.album);
+                        text("np-device", state.device ? "on " + state.device + (state.is_playing ? "" : " (paused)") : "");
+                        text("np-time", state.duration_ms ? time(progress) + " / " + time(state.duration_ms) : "");
+                        document.getElementById("np-progress").style.width = (state.duration_ms ? 100 * progress / state.duration_ms : 0) + "%";
+                    }
+                    // rebuilds the player's buttons when they change, unless one is being used
+                    function renderControls() {
+                        var box = document.getElementById("np-controls"), controls = JSON.stringify(state.controls || []);
+                        if (controls === shown || box.contains(document.activeElement)) { return; }
+                        shown = controls;
+                        box.textContent = "";
+                        (state.controls || []).forEach(function (c) {
+                            var form = document.createElement("form");
+                            form.action = c.action;
+                            form.method = "post";
+                            form.className = "form-inline m-1";
+                            if (c.input) {
+                                var input = document.createElement("input");
+                                input.type = "number";
+                                input.className = "form-control mr-1";
+                                input.style.width = "6rem";
+                                input.name = c.input;
+                                input.value = c.value || "";
+                                input.setAttribute("aria-label", c.label);
+                                form.appendChild(input);
+                            }
+                            var button = document.createElement("button");
+                            button.type = "submit";
+                            button.className = "btn " + (c.active ? "btn-primary" : "btn-outline-primary");
+                            button.textContent = c.label;
+                            form.appendChild(button);
+                            box.appendChild(form);
+                        });
+                    }
+                    function refresh() {
+                        fetch(document.getElementById("now-playing").dataset.refresh).then(function (res) { return res.json(); }).then(function (s) {
+                            state = s;
+                            fetched = Date.now();
+                            render();
+                            renderControls();
+                        });
+                    }
+                    refresh();
+                    setInterval(refresh, 5000);
+                    setInterval(render, 1000);
+                })();
+                </script>
+                {{end}}
+
+                {{if .Controls}}
+                <div class="d-flex flex-wrap justify-content-center my-3">
+                    {{range .Controls}}
+                    <form action="{{.Action}}" method="post" class="form-inline m-1">
+                        {{if .Input}}<input type="number" class="form-control mr-1" style="width: 6rem;" name="{{.Input}}" value="{{.Value}}" aria-label="{{.Label}}">{{end}}
+                        <button type="submit" class="btn {{if .Active}}btn-primary{{else}}btn-outline-primary{{end}}">{{.Label}}</button>
+                    </form>
+                    {{end}}
+                </div>
+                {{end}}
+
+                {{with .Form}}
+                <form action="{{.Action}}" method="post" class="text-left">
+                    {{range .Fields}}
+                    <div class="form-group">
+                        <label for="{{.Name}}">{{.Label}}</label>
+                        {{if .Options}}
+                        <select class="form-control" id="{{.Name}}" name="{{.Name}}">
+                            {{$value := .Value}}
+                            {{range .Options}}<option value="{{.}}" {{if eq . $value}}selected{{end}}>{{.}}</option>{{end}}
+                        </select>
+                        {{else}}
+                        <input type="{{if .Type}}{{.Type}}{{else}}text{{end}}" class="form-control" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}">
+                        {{end}}
+                    </div>
+                    {{end}}
+                    <button type="submit" class="btn btn-primary">{{if .Submit}}{{.Submit}}{{else}}Save{{end}}</button>
+                </form>
+                {{end}}
             </div>
         </section>
     </main> 
@@ -70,13 +188,85 @@ const askTpl = `
 
 type Page struct {
 	Title      string
+	Alerts     []Alert
 	Questions  []Item
+	Form       *Form
+	NowPlaying *NowPlaying
+	Controls   []Control
+	Refresh    *Refresh
 	ShowButton bool
 }
 
+// Refresh loads URL once the page has been shown for Seconds
+type Refresh struct {
+	URL     string
+	Seconds int
+}
+
 type Item struct {
-	Text string
-	Path string
+	Text  string
+	Path  string
+	Image string
+}
+
+// Alert is a banner shown under the title, Level is a bootstrap alert style
+// such as "danger" or "success"
+type Alert struct {
+	Level string
+	Text  string
+}
+
+type Form struct {
+	Action string
+	Fields []Field
+	Submit string
+}
+
+// Field is a form input, it is shown as a drop down when Options are given
+type Field struct {
+	Label   string
+	Name    string
+	Value   string
+	Type    string
+	Options []string
+}
+
+// Control is a button that posts to Action. When Input is set the button has
+// a number field of that name, filled in with Value. Active highlights it.
+type Control struct {
+	Label  string `json:"label"`
+	Action string `json:"action"`
+	Input  string `json:"input,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Active bool   `json:"active"`
+}
+
+// NowPlaying is what's playing on Spotify. It's shown as a card that keeps
+// itself up to date from the Refresh URL, which serves the same fields as JSON.
+// Controls are the player's buttons under the card, they're kept up to date
+// with it.
+type NowPlaying struct {
+	Refresh    string    `json:"-"`
+	Active     bool      `json:"active"`
+	IsPlaying  bool      `json:"is_playing"`
+	Track      string    `json:"track"`
+	Artists    string    `json:"artists"`
+	Album      string    `json:"album"`
+	AlbumURI   string    `json:"album_uri"`
+	ImageURL   string    `json:"image_url"`
+	Device     string    `json:"device"`
+	Volume     int       `json:"volume"`
+	ProgressMS int       `json:"progress_ms"`
+	DurationMS int       `json:"duration_ms"`
+	Controls   []Control `json:"controls"`
+}
+
+// Percent is how far through the track we are
+func (n NowPlaying) Percent() int {
+	if n.DurationMS == 0 {
+		return 0
+	}
+	return 100 * n.ProgressMS / n.DurationMS
 }
 
 // Show writes the main web page with the given info
@@ -88,11 +278,21 @@ func Show(w io.Writer, page Page) {
 
 	data := struct {
 		Title      string
+		Alerts     []Alert
 		Items      []Item
+		Form       *Form
+		NowPlaying *NowPlaying
+		Controls   []Control
+		Refresh    *Refresh
 		ShowButton bool
 	}{
 		Title:      page.Title,
+		Alerts:     page.Alerts,
 		Items:      page.Questions,
+		Form:       page.Form,
+		NowPlaying: page.NowPlaying,
+		Controls:   page.Controls,
+		Refresh:    page.Refresh,
 		ShowButton: page.ShowButton,
 	}
 